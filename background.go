@@ -0,0 +1,92 @@
+package mozjpegbin
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// srgbToLinear maps 8-bit sRGB values to linear light.
+var srgbToLinear = func() (table [256]float64) {
+	for i := range table {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			table[i] = v / 12.92
+		} else {
+			table[i] = math.Pow((v+0.055)/1.055, 2.4)
+		}
+	}
+
+	return table
+}()
+
+// linearToSRGB maps linear light, quantized to 4096 steps, to 8-bit sRGB values.
+var linearToSRGB = func() (table [4096]uint8) {
+	for i := range table {
+		v := float64(i) / float64(len(table)-1)
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+
+		table[i] = uint8(math.Round(v * 255))
+	}
+
+	return table
+}()
+
+// flatten composites img over bg, so that no alpha is left for JPEG to drop.
+// Blending happens in linear light, avoiding the dark fringes of blending sRGB values.
+// Opaque images are returned unchanged. A nil bg is white.
+func flatten(img image.Image, bg color.Color) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+
+	if bg == nil {
+		bg = color.White
+	}
+
+	background := color.NRGBAModel.Convert(bg).(color.NRGBA)
+	bgLinear := [3]float64{srgbToLinear[background.R], srgbToLinear[background.G], srgbToLinear[background.B]}
+
+	b := img.Bounds()
+	out := image.NewRGBA(b)
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		i := out.PixOffset(b.Min.X, y)
+
+		for x := b.Min.X; x < b.Max.X; x, i = x+1, i+4 {
+			c := straightAt(img, x, y)
+
+			a := float64(c.A) / 255
+			for j, v := range [3]uint8{c.R, c.G, c.B} {
+				linear := a*srgbToLinear[v] + (1-a)*bgLinear[j]
+				out.Pix[i+j] = linearToSRGB[int(linear*float64(len(linearToSRGB)-1)+0.5)]
+			}
+
+			out.Pix[i+3] = 0xFF
+		}
+	}
+
+	return out
+}
+
+// straightAt returns the color of img at x, y with non-premultiplied components.
+func straightAt(img image.Image, x, y int) color.NRGBA {
+	switch img := img.(type) {
+	case *image.NRGBA:
+		return img.NRGBAAt(x, y)
+	case *image.YCbCr:
+		c := img.YCbCrAt(x, y)
+		r, g, b := color.YCbCrToRGB(c.Y, c.Cb, c.Cr)
+		return color.NRGBA{R: r, G: g, B: b, A: 0xFF}
+	case *image.RGBA:
+		if c := img.RGBAAt(x, y); c.A == 0xFF {
+			return color.NRGBA(c)
+		}
+	}
+
+	return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+}
@@ -0,0 +1,83 @@
+package mozjpegbin_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/stretchr/testify/assert"
+)
+
+// transparentImage returns an image whose left half is fully transparent
+// and whose right half is red at 50% opacity.
+func transparentImage() image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 32; x < 64; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: 255, A: 128})
+		}
+	}
+	return img
+}
+
+func assertColor(t *testing.T, img image.Image, x, y int, r, g, b uint8) {
+	cr, cg, cb, _ := img.At(x, y).RGBA()
+	assert.InDelta(t, r, cr>>8, 6, "red at %d,%d", x, y)
+	assert.InDelta(t, g, cg>>8, 6, "green at %d,%d", x, y)
+	assert.InDelta(t, b, cb>>8, 6, "blue at %d,%d", x, y)
+}
+
+func TestEncodeBackground(t *testing.T) {
+	var out bytes.Buffer
+	err := mozjpegbin.Encode(&out, transparentImage(), &mozjpegbin.Options{Quality: 95})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	img, err := jpeg.Decode(&out)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	// 50% red over white, blended in linear light.
+	assertColor(t, img, 8, 8, 255, 255, 255)
+	assertColor(t, img, 56, 8, 255, 188, 188)
+
+	out.Reset()
+	err = mozjpegbin.Encode(&out, transparentImage(), &mozjpegbin.Options{Quality: 95, Background: color.Black})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	img, err = jpeg.Decode(&out)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assertColor(t, img, 8, 8, 0, 0, 0)
+	assertColor(t, img, 56, 8, 188, 0, 0)
+}
+
+func TestCJpegBackground(t *testing.T) {
+	c, err := mozjpegbin.NewCJpeg()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var out bytes.Buffer
+	err = c.InputImage(transparentImage()).Output(&out).Quality(95).Background(color.RGBA{B: 255, A: 255}).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	img, err := jpeg.Decode(&out)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assertColor(t, img, 8, 8, 0, 0, 255)
+	assertColor(t, img, 56, 8, 188, 0, 188)
+}
@@ -0,0 +1,144 @@
+package mozjpegbin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrNoCandidate is returned by BestOf when no candidate produced an output meeting the constraints.
+var ErrNoCandidate = errors.New("no candidate succeeded")
+
+// Candidate is a configuration tried by BestOf.
+type Candidate struct {
+	// Name identifies the candidate in results.
+	Name string
+	// Configure changes settings of a copy of the CJpeg BestOf was called on, e.g. calling Baseline(true).
+	Configure func(c *CJpeg)
+}
+
+// CandidateResult is the outcome of a candidate.
+type CandidateResult struct {
+	Name string
+	// Result is nil if the candidate failed.
+	Result *EncodeResult
+	// Err is the reason the candidate failed, e.g. an error wrapping ErrTargetSizeUnreachable.
+	Err error
+}
+
+// BestOfResult describes the outcome of BestOf.
+type BestOfResult struct {
+	// Winner is the index of the candidate whose output was written.
+	Winner int
+	// Candidates are the results of all candidates, in the order they were given.
+	Candidates []CandidateResult
+}
+
+// Concurrency sets the number of candidates BestOf, or variants Variants, encodes at the same time. The default is 1.
+func (c *CJpeg) Concurrency(n int) *CJpeg {
+	c.concurrency = max(n, 1)
+	return c
+}
+
+// BestOf encodes the input with every candidate and writes the smallest output to Output or OutputFile.
+// Every candidate starts from the settings of c. When TargetSize or TargetQuality is set,
+// every candidate searches for its own quality, and candidates that can't meet the target are left out.
+// Result then reports the winner's result.
+//
+// The input is read or serialized only once. Ties are won by the candidate given first.
+func (c *CJpeg) BestOf(candidates ...Candidate) (*BestOfResult, error) {
+	return c.BestOfContext(context.Background(), candidates...)
+}
+
+// BestOfContext works like BestOf. Encoding is stopped when ctx is done.
+func (c *CJpeg) BestOfContext(ctx context.Context, candidates ...Candidate) (*BestOfResult, error) {
+	c.result = nil
+
+	if len(candidates) == 0 {
+		return nil, errors.New("no candidates given")
+	}
+
+	if _, err := c.getOutput(); err != nil {
+		return nil, err
+	}
+
+	in, err := c.newInput(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := in.replayable(); err != nil {
+		return nil, err
+	}
+
+	icc, err := c.outputICC(in)
+
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := make([][]byte, len(candidates))
+	result := &BestOfResult{Winner: -1, Candidates: make([]CandidateResult, len(candidates))}
+	sem := make(chan struct{}, max(c.concurrency, 1))
+	var wg sync.WaitGroup
+
+	for i, candidate := range candidates {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			trial := *c
+			if candidate.Configure != nil {
+				candidate.Configure(&trial)
+			}
+
+			if trial.err != nil {
+				result.Candidates[i] = CandidateResult{Name: candidate.Name, Err: trial.err}
+				return
+			}
+
+			data, encodeResult, err := trial.encodeBuffered(ctx, in, icc)
+			outputs[i] = data
+			result.Candidates[i] = CandidateResult{Name: candidate.Name, Result: encodeResult, Err: err}
+		}()
+	}
+
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for i, candidate := range result.Candidates {
+		if candidate.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", candidate.Name, candidate.Err))
+			continue
+		}
+
+		if result.Winner < 0 || candidate.Result.Size < result.Candidates[result.Winner].Result.Size {
+			result.Winner = i
+		}
+	}
+
+	if result.Winner < 0 {
+		return result, fmt.Errorf("%w: %w", ErrNoCandidate, errors.Join(errs...))
+	}
+
+	data := outputs[result.Winner]
+	if err := c.writeOutput(data); err != nil {
+		return result, err
+	}
+
+	winner := *result.Candidates[result.Winner].Result
+	winner.Scans = indexScans(data)
+	c.result = &winner
+	return result, nil
+}
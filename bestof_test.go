@@ -0,0 +1,58 @@
+package mozjpegbin_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCJpegBestOf(t *testing.T) {
+	c, err := mozjpegbin.NewCJpeg()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var out bytes.Buffer
+	result, err := c.InputFile("source.jpg").Output(&out).Quality(80).Concurrency(2).BestOf(
+		mozjpegbin.Candidate{Name: "baseline", Configure: func(c *mozjpegbin.CJpeg) { c.Baseline(true) }},
+		mozjpegbin.Candidate{Name: "progressive"},
+		mozjpegbin.Candidate{Name: "arithmetic", Configure: func(c *mozjpegbin.CJpeg) { c.Arithmetic(true) }},
+		mozjpegbin.Candidate{Name: "dc-scan-opt", Configure: func(c *mozjpegbin.CJpeg) { c.DCScanOpt(2) }},
+		mozjpegbin.Candidate{Name: "444", Configure: func(c *mozjpegbin.CJpeg) { c.Subsample(image.YCbCrSubsampleRatio444) }},
+	)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Len(t, result.Candidates, 5)
+	assert.Equal(t, out.Len(), c.Result().Size)
+
+	for _, candidate := range result.Candidates {
+		assert.Nil(t, candidate.Err)
+		assert.Equal(t, 80, candidate.Result.Quality)
+		assert.GreaterOrEqual(t, candidate.Result.Size, out.Len())
+	}
+
+	assert.Less(t, result.Candidates[1].Result.Size, result.Candidates[4].Result.Size)
+}
+
+func TestCJpegBestOfConstraint(t *testing.T) {
+	c, err := mozjpegbin.NewCJpeg()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	result, err := c.InputFile("source.jpg").Output(&bytes.Buffer{}).TargetSize(100).BestOf(
+		mozjpegbin.Candidate{Name: "baseline", Configure: func(c *mozjpegbin.CJpeg) { c.Baseline(true) }},
+		mozjpegbin.Candidate{Name: "progressive"},
+	)
+
+	assert.True(t, errors.Is(err, mozjpegbin.ErrNoCandidate))
+	assert.True(t, errors.Is(err, mozjpegbin.ErrTargetSizeUnreachable))
+	assert.Equal(t, -1, result.Winner)
+	assert.Nil(t, c.Result())
+}
@@ -0,0 +1,823 @@
+//go:build mozjpeg_cgo && cgo && (linux || darwin)
+
+package mozjpegbin
+
+/*
+#cgo linux CFLAGS: -I${SRCDIR}/bin/linux/include
+#cgo linux LDFLAGS: ${SRCDIR}/bin/linux/libturbojpeg.a -lm
+#cgo darwin CFLAGS: -I${SRCDIR}/bin/macos/include
+#cgo darwin LDFLAGS: ${SRCDIR}/bin/macos/libturbojpeg.a -lm
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <setjmp.h>
+#include <jpeglib.h>
+#include <turbojpeg.h>
+
+typedef struct {
+	struct jpeg_error_mgr pub;
+	jmp_buf jump;
+	char message[JMSG_LENGTH_MAX];
+} mozjpeg_error_mgr;
+
+static void mozjpeg_error_exit(j_common_ptr cinfo) {
+	mozjpeg_error_mgr *err = (mozjpeg_error_mgr *)cinfo->err;
+	(*cinfo->err->format_message)(cinfo, err->message);
+	longjmp(err->jump, 1);
+}
+
+static void mozjpeg_output_message(j_common_ptr cinfo) {
+}
+
+// mozjpeg_encode compresses packed RGB or grayscale pixels with the same defaults as cjpeg.
+static int mozjpeg_encode(const unsigned char *pix, int width, int height, int components,
+		int quality, int baseline, unsigned char **out, unsigned long *out_size, char *errbuf, int errbuf_size) {
+	struct jpeg_compress_struct cinfo;
+	mozjpeg_error_mgr jerr;
+	JSAMPROW row;
+
+	*out = NULL;
+	*out_size = 0;
+
+	cinfo.err = jpeg_std_error(&jerr.pub);
+	jerr.pub.error_exit = mozjpeg_error_exit;
+	jerr.pub.output_message = mozjpeg_output_message;
+
+	if (setjmp(jerr.jump)) {
+		snprintf(errbuf, errbuf_size, "%s", jerr.message);
+		jpeg_destroy_compress(&cinfo);
+		if (*out != NULL) {
+			free(*out);
+			*out = NULL;
+		}
+		return -1;
+	}
+
+	jpeg_create_compress(&cinfo);
+	jpeg_mem_dest(&cinfo, out, out_size);
+
+	cinfo.image_width = width;
+	cinfo.image_height = height;
+	cinfo.input_components = components;
+	cinfo.in_color_space = components == 1 ? JCS_GRAYSCALE : JCS_RGB;
+
+	jpeg_set_defaults(&cinfo);
+
+	if (quality >= 0) {
+		jpeg_set_quality(&cinfo, quality, TRUE);
+	}
+
+	if (baseline) {
+		cinfo.num_scans = 0;
+		cinfo.scan_info = NULL;
+	}
+
+	jpeg_start_compress(&cinfo, TRUE);
+
+	while (cinfo.next_scanline < cinfo.image_height) {
+		row = (JSAMPROW)&pix[(size_t)cinfo.next_scanline * width * components];
+		jpeg_write_scanlines(&cinfo, &row, 1);
+	}
+
+	jpeg_finish_compress(&cinfo);
+	jpeg_destroy_compress(&cinfo);
+	return 0;
+}
+
+// mozjpeg_compress_planes builds the plane arrays on the C side, Go memory may not hold Go pointers passed to C.
+static int mozjpeg_compress_planes(tjhandle handle, const unsigned char *y, const unsigned char *cb, const unsigned char *cr,
+		int width, int y_stride, int c_stride, int height, int subsamp, int quality,
+		unsigned char **out, unsigned long *out_size) {
+	const unsigned char *planes[3] = {y, cb, cr};
+	int strides[3] = {y_stride, c_stride, c_stride};
+
+	return tjCompressFromYUVPlanes(handle, planes, width, strides, height, subsamp, out, out_size, quality, 0);
+}
+
+static int mozjpeg_mcu_width(int subsamp) {
+	return tjMCUWidth[subsamp];
+}
+
+static int mozjpeg_mcu_height(int subsamp) {
+	return tjMCUHeight[subsamp];
+}
+*/
+import "C"
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	// Formats cjpeg accepts besides PPM, decoded in Go by CgoRunner.
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// init makes Decode work in-process and CgoRunner the default runner, which Encode, NewCJpeg and NewJpegTran use.
+func init() {
+	inProcess = cgoCodec{}
+	defaultRunner = NewCgoRunner()
+}
+
+type cgoCodec struct{}
+
+func (cgoCodec) decode(data []byte) (image.Image, error) {
+	return cgoDecode(data, 1, 1)
+}
+
+func tjError() error {
+	return errors.New(C.GoString(C.tjGetErrorStr()))
+}
+
+// cgoEncode compresses img with libjpeg using mozjpeg's defaults.
+// quality is between 0 and 100, or -1 for the default.
+func cgoEncode(img image.Image, quality int, baseline bool) ([]byte, error) {
+	pix, components := packPixels(img)
+	bounds := img.Bounds()
+
+	if len(pix) == 0 {
+		return nil, errors.New("empty image")
+	}
+
+	var out *C.uchar
+	var outSize C.ulong
+	var errbuf [C.JMSG_LENGTH_MAX]C.char
+
+	b := 0
+	if baseline {
+		b = 1
+	}
+
+	rc := C.mozjpeg_encode((*C.uchar)(unsafe.Pointer(&pix[0])), C.int(bounds.Dx()), C.int(bounds.Dy()), C.int(components),
+		C.int(quality), C.int(b), &out, &outSize, &errbuf[0], C.int(len(errbuf)))
+
+	if out != nil {
+		defer C.free(unsafe.Pointer(out))
+	}
+
+	if rc != 0 {
+		return nil, fmt.Errorf("failed to encode: %s", C.GoString(&errbuf[0]))
+	}
+
+	return C.GoBytes(unsafe.Pointer(out), C.int(outSize)), nil
+}
+
+// tjSubsampling maps image.YCbCr subsample ratios to TurboJPEG subsampling.
+var tjSubsampling = map[image.YCbCrSubsampleRatio]C.int{
+	image.YCbCrSubsampleRatio444: C.TJSAMP_444,
+	image.YCbCrSubsampleRatio422: C.TJSAMP_422,
+	image.YCbCrSubsampleRatio420: C.TJSAMP_420,
+	image.YCbCrSubsampleRatio440: C.TJSAMP_440,
+	image.YCbCrSubsampleRatio411: C.TJSAMP_411,
+}
+
+// cgoEncodeYCbCr compresses the planes of img with TurboJPEG, keeping its subsampling.
+func cgoEncodeYCbCr(img *image.YCbCr, quality int) ([]byte, error) {
+	subsamp, ok := tjSubsampling[img.SubsampleRatio]
+	if !ok {
+		return nil, fmt.Errorf("%w %v", errUnsupportedSubsampling, img.SubsampleRatio)
+	}
+
+	h, v, _ := samplingFactors(img.SubsampleRatio)
+	bounds := img.Bounds()
+
+	// TurboJPEG expects chroma planes to start at the first pixel of the image.
+	if bounds.Empty() || bounds.Min.X%h != 0 || bounds.Min.Y%v != 0 {
+		return nil, fmt.Errorf("%w: image origin %v isn't aligned to chroma samples", errUnsupportedSubsampling, bounds.Min)
+	}
+
+	if quality < 0 {
+		quality = 75
+	}
+
+	handle := C.tjInitCompress()
+	if handle == nil {
+		return nil, tjError()
+	}
+	defer C.tjDestroy(handle)
+
+	var out *C.uchar
+	var outSize C.ulong
+
+	rc := C.mozjpeg_compress_planes(handle,
+		(*C.uchar)(unsafe.Pointer(&img.Y[img.YOffset(bounds.Min.X, bounds.Min.Y)])),
+		(*C.uchar)(unsafe.Pointer(&img.Cb[img.COffset(bounds.Min.X, bounds.Min.Y)])),
+		(*C.uchar)(unsafe.Pointer(&img.Cr[img.COffset(bounds.Min.X, bounds.Min.Y)])),
+		C.int(bounds.Dx()), C.int(img.YStride), C.int(img.CStride), C.int(bounds.Dy()),
+		subsamp, C.int(quality), &out, &outSize)
+
+	if out != nil {
+		defer C.tjFree(out)
+	}
+
+	if rc != 0 {
+		return nil, tjError()
+	}
+
+	return C.GoBytes(unsafe.Pointer(out), C.int(outSize)), nil
+}
+
+// packPixels returns the pixels of img as packed 8 bit RGB, or grayscale for gray images.
+func packPixels(img image.Image) ([]byte, int) {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	if gray, ok := img.(*image.Gray); ok {
+		pix := make([]byte, w*h)
+		for y := 0; y < h; y++ {
+			copy(pix[y*w:], gray.Pix[gray.PixOffset(bounds.Min.X, bounds.Min.Y+y):][:w])
+		}
+
+		return pix, 1
+	}
+
+	pix := make([]byte, w*h*3)
+	i := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			pix[i], pix[i+1], pix[i+2] = c.R, c.G, c.B
+			i += 3
+		}
+	}
+
+	return pix, 3
+}
+
+// jpegHeader is the part of a JPEG header TurboJPEG reports.
+type jpegHeader struct {
+	width, height, subsamp int
+}
+
+func cgoDecodeHeader(handle C.tjhandle, data []byte) (*jpegHeader, error) {
+	var width, height, subsamp, colorspace C.int
+
+	rc := C.tjDecompressHeader3(handle, (*C.uchar)(unsafe.Pointer(&data[0])), C.ulong(len(data)),
+		&width, &height, &subsamp, &colorspace)
+	if rc != 0 {
+		return nil, tjError()
+	}
+
+	return &jpegHeader{width: int(width), height: int(height), subsamp: int(subsamp)}, nil
+}
+
+// cgoCheckHeader returns an error if TurboJPEG can't read the header of data.
+func cgoCheckHeader(data []byte) error {
+	if len(data) == 0 {
+		return errors.New("empty jpeg data")
+	}
+
+	handle := C.tjInitDecompress()
+	if handle == nil {
+		return tjError()
+	}
+	defer C.tjDestroy(handle)
+
+	_, err := cgoDecodeHeader(handle, data)
+	return err
+}
+
+// cgoDecode decompresses data scaled by num/denom, which must be one of the factors TurboJPEG supports.
+func cgoDecode(data []byte, num, denom int) (image.Image, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty jpeg data")
+	}
+
+	handle := C.tjInitDecompress()
+	if handle == nil {
+		return nil, tjError()
+	}
+	defer C.tjDestroy(handle)
+
+	header, err := cgoDecodeHeader(handle, data)
+	if err != nil {
+		return nil, err
+	}
+
+	width := (header.width*num + denom - 1) / denom
+	height := (header.height*num + denom - 1) / denom
+	rect := image.Rect(0, 0, width, height)
+
+	var img image.Image
+	var pix []byte
+	var stride int
+	var format C.int
+
+	if header.subsamp == C.TJSAMP_GRAY {
+		gray := image.NewGray(rect)
+		img, pix, stride, format = gray, gray.Pix, gray.Stride, C.TJPF_GRAY
+	} else {
+		rgba := image.NewRGBA(rect)
+		img, pix, stride, format = rgba, rgba.Pix, rgba.Stride, C.TJPF_RGBA
+	}
+
+	rc := C.tjDecompress2(handle, (*C.uchar)(unsafe.Pointer(&data[0])), C.ulong(len(data)),
+		(*C.uchar)(unsafe.Pointer(&pix[0])), C.int(width), C.int(stride), C.int(height), format, 0)
+	if rc != 0 {
+		return nil, tjError()
+	}
+
+	return img, nil
+}
+
+// transformSpec is a lossless transformation in terms of TurboJPEG.
+type transformSpec struct {
+	op      C.int
+	crop    *cropInfo
+	perfect bool
+	trim    bool
+	gray    bool
+}
+
+// cgoTransform transforms data losslessly. Like jpegtran, the crop origin is moved to the iMCU boundary.
+func cgoTransform(data []byte, spec *transformSpec) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty jpeg data")
+	}
+
+	handle := C.tjInitTransform()
+	if handle == nil {
+		return nil, tjError()
+	}
+	defer C.tjDestroy(handle)
+
+	var xf C.tjtransform
+	xf.op = spec.op
+
+	if spec.perfect {
+		xf.options |= C.TJXOPT_PERFECT
+	}
+
+	if spec.trim {
+		xf.options |= C.TJXOPT_TRIM
+	}
+
+	if spec.gray {
+		xf.options |= C.TJXOPT_GRAY
+	}
+
+	if spec.crop != nil {
+		header, err := cgoDecodeHeader(handle, data)
+		if err != nil {
+			return nil, err
+		}
+
+		width, height := header.width, header.height
+		mcuWidth := int(C.mozjpeg_mcu_width(C.int(header.subsamp)))
+		mcuHeight := int(C.mozjpeg_mcu_height(C.int(header.subsamp)))
+
+		// The crop region is relative to the transformed image.
+		switch spec.op {
+		case C.TJXOP_TRANSPOSE, C.TJXOP_TRANSVERSE, C.TJXOP_ROT90, C.TJXOP_ROT270:
+			width, height = height, width
+			mcuWidth, mcuHeight = mcuHeight, mcuWidth
+		}
+
+		c := spec.crop
+		if c.width <= 0 || c.height <= 0 || c.x < 0 || c.y < 0 || c.x+c.width > width || c.y+c.height > height {
+			return nil, errors.New("Invalid crop request")
+		}
+
+		x := c.x - c.x%mcuWidth
+		y := c.y - c.y%mcuHeight
+
+		xf.options |= C.TJXOPT_CROP
+		xf.r.x = C.int(x)
+		xf.r.y = C.int(y)
+		xf.r.w = C.int(c.width + c.x - x)
+		xf.r.h = C.int(c.height + c.y - y)
+	}
+
+	var out *C.uchar
+	var outSize C.ulong
+
+	rc := C.tjTransform(handle, (*C.uchar)(unsafe.Pointer(&data[0])), C.ulong(len(data)), 1, &out, &outSize, &xf, 0)
+
+	if out != nil {
+		defer C.tjFree(out)
+	}
+
+	if rc != 0 {
+		return nil, tjError()
+	}
+
+	return C.GoBytes(unsafe.Pointer(out), C.int(outSize)), nil
+}
+
+// stripMarkers removes the markers jpegtran wouldn't copy with the given -copy mode.
+// JFIF and Adobe markers are kept, as they are written by the library itself.
+func stripMarkers(data []byte, copyMode string) ([]byte, error) {
+	if copyMode == "all" {
+		return data, nil
+	}
+
+	segments, rest, err := splitHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := segments[:0]
+	for _, s := range segments {
+		switch {
+		case s.marker == markerAPP0 && s.hasPrefix("JFIF\x00"):
+		case s.marker == markerAPP0+14 && s.hasPrefix("Adobe"):
+		case s.marker == markerCOM && copyMode == "comments":
+		case s.isAPP() || s.marker == markerCOM:
+			continue
+		}
+
+		kept = append(kept, s)
+	}
+
+	return joinHeader(kept, rest), nil
+}
+
+// errUnsupportedInvocation makes CgoRunner delegate an invocation to its fallback.
+var errUnsupportedInvocation = errors.New("invocation is not supported in-process")
+
+// CgoRunner runs cjpeg, jpegtran and djpeg in-process with the bundled libjpeg and TurboJPEG libraries.
+// It's available when built with the mozjpeg_cgo tag and is the DefaultRunner in that case.
+//
+// Only the switches used by the builders of this package are handled in-process:
+// cjpeg -quality, -optimize, -progressive, -baseline and -outfile with PPM, PGM, JPEG and PNG input,
+// jpegtran -copy, -crop, -rotate, -flip, -transpose, -transverse, -perfect, -trim, -grayscale, -optimize, -progressive, -revert and -outfile,
+// djpeg -pnm, -scale, -grayscale and -outfile.
+// Other invocations are delegated to the fallback runner, an EmbeddedRunner by default.
+// It's safe for concurrent use.
+type CgoRunner struct {
+	fallback Runner
+}
+
+// NewCgoRunner creates CgoRunner instance
+func NewCgoRunner() *CgoRunner {
+	return &CgoRunner{fallback: NewEmbeddedRunner()}
+}
+
+func (r *CgoRunner) encodeYCbCr(img *image.YCbCr, quality int) ([]byte, error) {
+	return cgoEncodeYCbCr(img, quality)
+}
+
+// Fallback sets the runner for invocations that can't be handled in-process.
+func (r *CgoRunner) Fallback(runner Runner) *CgoRunner {
+	r.fallback = runner
+	return r
+}
+
+// Run runs inv in-process, or with the fallback runner if that's not possible.
+func (r *CgoRunner) Run(ctx context.Context, inv *Invocation) (*RunResult, error) {
+	var process func([]byte) ([]byte, error)
+	var outfile, infile string
+	var err error
+
+	switch inv.Tool {
+	case "cjpeg":
+		process, outfile, infile, err = parseCJpegArgs(inv.Args)
+	case "jpegtran":
+		process, outfile, infile, err = parseJpegTranArgs(inv.Args)
+	case "djpeg":
+		process, outfile, infile, err = parseDJpegArgs(inv.Args)
+	default:
+		err = errUnsupportedInvocation
+	}
+
+	if err == errUnsupportedInvocation {
+		return r.fallback.Run(ctx, inv)
+	} else if err != nil {
+		return &RunResult{Stderr: []byte(err.Error())}, errors.New("invalid arguments")
+	}
+
+	var input []byte
+	if infile != "" {
+		input, err = os.ReadFile(infile)
+	} else if inv.Stdin != nil {
+		input, err = io.ReadAll(inv.Stdin)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	output, err := process(input)
+
+	if err == errUnsupportedInvocation {
+		// The input was consumed, hand it over to the fallback.
+		fallback := *inv
+		if infile == "" {
+			fallback.Stdin = bytes.NewReader(input)
+		}
+
+		return r.fallback.Run(ctx, &fallback)
+	} else if err != nil {
+		return &RunResult{Stderr: []byte(err.Error())}, fmt.Errorf("%s failed", inv.Tool)
+	}
+
+	res := &RunResult{}
+
+	switch {
+	case outfile != "":
+		err = os.WriteFile(outfile, output, 0644)
+	case inv.Stdout != nil:
+		_, err = inv.Stdout.Write(output)
+	default:
+		res.Stdout = output
+	}
+
+	return res, err
+}
+
+// argParser walks tool arguments. The last argument not belonging to a switch is the input file.
+type argParser struct {
+	args    []string
+	pos     int
+	outfile string
+	infile  string
+}
+
+func (p *argParser) next() (string, bool) {
+	if p.pos >= len(p.args) {
+		return "", false
+	}
+
+	arg := p.args[p.pos]
+	p.pos++
+	return arg, true
+}
+
+func (p *argParser) value() (string, error) {
+	v, ok := p.next()
+	if !ok {
+		return "", fmt.Errorf("missing value for %s", p.args[p.pos-1])
+	}
+
+	return v, nil
+}
+
+// common handles arguments all tools share. It reports false for an unknown switch.
+func (p *argParser) common(arg string) (bool, error) {
+	if arg == "-outfile" {
+		v, err := p.value()
+		p.outfile = v
+		return true, err
+	}
+
+	if !strings.HasPrefix(arg, "-") {
+		p.infile = arg
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func parseCJpegArgs(args []string) (func([]byte) ([]byte, error), string, string, error) {
+	p := &argParser{args: args}
+	quality := -1
+	baseline := false
+
+	for arg, ok := p.next(); ok; arg, ok = p.next() {
+		switch arg {
+		case "-quality":
+			v, err := p.value()
+			if err != nil {
+				return nil, "", "", err
+			}
+
+			if strings.Contains(v, ",") {
+				return nil, "", "", errUnsupportedInvocation
+			}
+
+			quality, err = strconv.Atoi(v)
+			if err != nil {
+				return nil, "", "", fmt.Errorf("invalid quality %q", v)
+			}
+		case "-optimize", "-progressive":
+			// Enabled by default in mozjpeg.
+		case "-baseline":
+			baseline = true
+		default:
+			known, err := p.common(arg)
+			if err != nil {
+				return nil, "", "", err
+			}
+
+			if !known {
+				return nil, "", "", errUnsupportedInvocation
+			}
+		}
+	}
+
+	process := func(input []byte) ([]byte, error) {
+		img, err := decodeCJpegInput(input)
+		if err != nil {
+			return nil, errUnsupportedInvocation
+		}
+
+		return cgoEncode(img, quality, baseline)
+	}
+
+	return process, p.outfile, p.infile, nil
+}
+
+func decodeCJpegInput(input []byte) (image.Image, error) {
+	if bytes.HasPrefix(input, []byte("P5")) || bytes.HasPrefix(input, []byte("P6")) {
+		return readPNM(bytes.NewReader(input))
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(input))
+	return img, err
+}
+
+func parseJpegTranArgs(args []string) (func([]byte) ([]byte, error), string, string, error) {
+	p := &argParser{args: args}
+	spec := &transformSpec{op: C.TJXOP_NONE}
+	copyMode := "comments"
+
+	setOp := func(op C.int) error {
+		if spec.op != C.TJXOP_NONE {
+			return errors.New("only one transformation may be specified")
+		}
+
+		spec.op = op
+		return nil
+	}
+
+	for arg, ok := p.next(); ok; arg, ok = p.next() {
+		var err error
+
+		switch arg {
+		case "-optimize", "-progressive":
+			// Enabled by default in mozjpeg.
+		case "-revert":
+			// TurboJPEG uses the libjpeg defaults.
+		case "-copy":
+			copyMode, err = p.value()
+			if err == nil && copyMode != "none" && copyMode != "comments" && copyMode != "all" {
+				err = fmt.Errorf("invalid copy mode %q", copyMode)
+			}
+		case "-crop":
+			var v string
+			v, err = p.value()
+			if err == nil {
+				spec.crop = &cropInfo{}
+				_, err = fmt.Sscanf(v, "%dx%d+%d+%d", &spec.crop.width, &spec.crop.height, &spec.crop.x, &spec.crop.y)
+			}
+		case "-rotate":
+			var v string
+			v, err = p.value()
+			switch {
+			case err != nil:
+			case v == "90":
+				err = setOp(C.TJXOP_ROT90)
+			case v == "180":
+				err = setOp(C.TJXOP_ROT180)
+			case v == "270":
+				err = setOp(C.TJXOP_ROT270)
+			default:
+				err = fmt.Errorf("invalid rotation %q", v)
+			}
+		case "-flip":
+			var v string
+			v, err = p.value()
+			switch {
+			case err != nil:
+			case strings.HasPrefix("horizontal", v):
+				err = setOp(C.TJXOP_HFLIP)
+			case strings.HasPrefix("vertical", v):
+				err = setOp(C.TJXOP_VFLIP)
+			default:
+				err = fmt.Errorf("invalid flip %q", v)
+			}
+		case "-transpose":
+			err = setOp(C.TJXOP_TRANSPOSE)
+		case "-transverse":
+			err = setOp(C.TJXOP_TRANSVERSE)
+		case "-perfect":
+			spec.perfect = true
+		case "-trim":
+			spec.trim = true
+		case "-grayscale":
+			spec.gray = true
+		default:
+			var known bool
+			known, err = p.common(arg)
+			if err == nil && !known {
+				return nil, "", "", errUnsupportedInvocation
+			}
+		}
+
+		if err != nil {
+			return nil, "", "", err
+		}
+	}
+
+	process := func(input []byte) ([]byte, error) {
+		if err := cgoCheckHeader(input); err != nil {
+			// E.g. subsampling TurboJPEG doesn't know, jpegtran may still handle it.
+			return nil, errUnsupportedInvocation
+		}
+
+		output, err := cgoTransform(input, spec)
+		if err != nil {
+			return nil, err
+		}
+
+		return stripMarkers(output, copyMode)
+	}
+
+	return process, p.outfile, p.infile, nil
+}
+
+func parseDJpegArgs(args []string) (func([]byte) ([]byte, error), string, string, error) {
+	p := &argParser{args: args}
+	num, denom := 1, 1
+	gray := false
+
+	for arg, ok := p.next(); ok; arg, ok = p.next() {
+		switch arg {
+		case "-pnm":
+		case "-grayscale":
+			gray = true
+		case "-scale":
+			v, err := p.value()
+			if err != nil {
+				return nil, "", "", err
+			}
+
+			if _, err := fmt.Sscanf(v, "%d/%d", &num, &denom); err != nil || num <= 0 || denom <= 0 {
+				return nil, "", "", fmt.Errorf("invalid scale %q", v)
+			}
+
+			if !tjScalingSupported(num, denom) {
+				return nil, "", "", errUnsupportedInvocation
+			}
+		default:
+			known, err := p.common(arg)
+			if err != nil {
+				return nil, "", "", err
+			}
+
+			if !known {
+				return nil, "", "", errUnsupportedInvocation
+			}
+		}
+	}
+
+	process := func(input []byte) ([]byte, error) {
+		img, err := cgoDecode(input, num, denom)
+		if err != nil {
+			return nil, err
+		}
+
+		if gray {
+			img = toGray(img)
+		}
+
+		var out bytes.Buffer
+		err = writePNM(&out, img)
+		return out.Bytes(), err
+	}
+
+	return process, p.outfile, p.infile, nil
+}
+
+// tjScalingSupported reports whether TurboJPEG can scale by num/denom while decoding.
+func tjScalingSupported(num, denom int) bool {
+	var n C.int
+	factors := C.tjGetScalingFactors(&n)
+
+	for _, f := range unsafe.Slice(factors, int(n)) {
+		if int(f.num)*denom == num*int(f.denom) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func toGray(img image.Image) *image.Gray {
+	if gray, ok := img.(*image.Gray); ok {
+		return gray
+	}
+
+	bounds := img.Bounds()
+	gray := image.NewGray(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			gray.Set(x, y, img.At(x, y))
+		}
+	}
+
+	return gray
+}
@@ -0,0 +1,178 @@
+//go:build mozjpeg_cgo && cgo && (linux || darwin)
+
+package mozjpegbin_test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCgoIsDefaultRunner(t *testing.T) {
+	_, ok := mozjpegbin.DefaultRunner().(*mozjpegbin.CgoRunner)
+	assert.True(t, ok)
+}
+
+func TestCgoEncode(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 123, 77))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+
+	var out bytes.Buffer
+	err := mozjpegbin.Encode(&out, img, &mozjpegbin.Options{Quality: 80})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	decoded, err := mozjpegbin.Decode(&out)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, img.Bounds(), decoded.Bounds())
+}
+
+func TestCgoEncodeUsesDefaultRunner(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+
+	var out bytes.Buffer
+	result, err := mozjpegbin.EncodeWithResult(&out, img, &mozjpegbin.Options{Quality: 80, Optimize: true})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	assert.NotEmpty(t, result.Scans)
+
+	prev := mozjpegbin.DefaultRunner()
+	defer mozjpegbin.SetDefaultRunner(prev)
+
+	fake := &fakeRunner{output: []byte("fake")}
+	if !assert.Nil(t, mozjpegbin.SetDefaultRunner(fake)) {
+		t.FailNow()
+	}
+
+	out.Reset()
+	err = mozjpegbin.Encode(&out, img, &mozjpegbin.Options{Quality: 80})
+	if assert.Nil(t, err) {
+		assert.Equal(t, "fake", out.String())
+		assert.Len(t, fake.invocations, 1)
+	}
+}
+
+func TestCgoEncodeYCbCrOptions(t *testing.T) {
+	img := image.NewYCbCr(image.Rect(0, 0, 32, 32), image.YCbCrSubsampleRatio420)
+
+	var out bytes.Buffer
+	err := mozjpegbin.NewCJpegWithRunner(mozjpegbin.NewCgoRunner()).
+		Baseline(true).
+		InputImage(img).
+		Output(&out).
+		Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	info, err := mozjpegbin.Inspect(&out)
+	if assert.Nil(t, err) {
+		assert.False(t, info.Progressive)
+	}
+
+	out.Reset()
+	err = mozjpegbin.NewCJpegWithRunner(mozjpegbin.NewCgoRunner()).
+		Arithmetic(true).
+		InputImage(img).
+		Output(&out).
+		Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	info, err = mozjpegbin.Inspect(&out)
+	if assert.Nil(t, err) {
+		assert.True(t, info.Arithmetic)
+	}
+}
+
+func TestCgoJpegTranMatchesEmbedded(t *testing.T) {
+	for _, crop := range [][4]int{{0, 0, 100, 100}, {13, 21, 333, 222}, {1100, 800, 103, 101}} {
+		var inProcess, embedded bytes.Buffer
+
+		err := mozjpegbin.NewJpegTranWithRunner(mozjpegbin.NewCgoRunner()).
+			Crop(crop[0], crop[1], crop[2], crop[3]).
+			InputFile("source.jpg").
+			Output(&inProcess).
+			Run()
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+
+		err = mozjpegbin.NewJpegTranWithRunner(mozjpegbin.NewEmbeddedRunner()).
+			Crop(crop[0], crop[1], crop[2], crop[3]).
+			InputFile("source.jpg").
+			Output(&embedded).
+			Run()
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+
+		a, err := jpeg.DecodeConfig(&inProcess)
+		assert.Nil(t, err)
+		b, err := jpeg.DecodeConfig(&embedded)
+		assert.Nil(t, err)
+		assert.Equal(t, b, a, "crop %v", crop)
+	}
+}
+
+func TestCgoJpegTranInvalidCrop(t *testing.T) {
+	err := mozjpegbin.NewJpegTranWithRunner(mozjpegbin.NewCgoRunner()).
+		Crop(1100, 800, 500, 500).
+		InputFile("source.jpg").
+		Output(&bytes.Buffer{}).
+		Run()
+	assert.NotNil(t, err)
+}
+
+func TestCgoRunnerFallback(t *testing.T) {
+	fallback := &fakeRunner{output: []byte("fallback")}
+	r := mozjpegbin.NewCgoRunner().Fallback(fallback)
+
+	var out bytes.Buffer
+	err := mozjpegbin.NewCJpegWithRunner(r).
+		Input(strings.NewReader("P3 not supported in-process")).
+		Output(&out).
+		Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, "fallback", out.String())
+	if assert.Len(t, fallback.stdin, 1) {
+		assert.Equal(t, "P3 not supported in-process", string(fallback.stdin[0]))
+	}
+}
+
+func TestCgoDJpegScale(t *testing.T) {
+	f, err := os.Open("source.jpg")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer f.Close()
+
+	var out bytes.Buffer
+	_, err = mozjpegbin.NewCgoRunner().Run(context.Background(), &mozjpegbin.Invocation{
+		Tool:   "djpeg",
+		Args:   []string{"-scale", "1/4"},
+		Stdin:  f,
+		Stdout: &out,
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	assert.True(t, strings.HasPrefix(out.String(), "P6\n301 226\n"))
+}
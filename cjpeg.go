@@ -1,39 +1,96 @@
 package mozjpegbin
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"image"
+	"image/color"
 	"io"
+	"os"
 
 	"github.com/Munchpass/go-mozjpegbin/embedbinwrapper"
+	"golang.org/x/image/draw"
 )
 
 // CJpeg wraps cjpeg tool from mozjpeg
 type CJpeg struct {
-	BinWrapper *embedbinwrapper.EmbedBinWrapper
-	inputFile  string
-	inputImage image.Image
-	input      io.Reader
-	outputFile string
-	output     io.Writer
-	quality    int
-	optimize   bool
+	// Deprecated: BinWrapper is only set when Runner is an EmbeddedRunner, and only its settings are used:
+	// each run uses a copy of it. Use Runner instead.
+	BinWrapper  *embedbinwrapper.EmbedBinWrapper
+	Runner      Runner
+	inputFile   string
+	inputImage  image.Image
+	background  color.Color
+	resize      Resize
+	input       io.Reader
+	outputFile  string
+	output      io.Writer
+	quality     int
+	optimize    bool
+	baseline    bool
+	arithmetic  bool
+	dcScanOpt   int
+	sample      string
+	revert      bool
+	quantTables [][64]int
+	targetSize  int
+	target      *qualityTarget
+	concurrency int
+	smart       bool
+	metadata    *MetadataPolicy
+	keepICC     bool
+	iccProfile  []byte
+	minSaving   float64
+	toolchain   *Toolchain
+	err         error
+	result      *EncodeResult
 }
 
-// NewCJpeg creates new CJpeg instance
+// EncodeResult describes the output of a CJpeg run.
+type EncodeResult struct {
+	// Quality is the quality the output was encoded with.
+	Quality int
+	// Size is the size of the output in bytes.
+	Size int
+	// Trials is the number of times the image was encoded.
+	Trials int
+	// Score is the value of the metric set with TargetQuality, zero otherwise.
+	Score float64
+	// SourceQuality is the estimated quality of a JPEG input with SmartRecompress, zero otherwise.
+	SourceQuality int
+	// Recompression tells which output SmartRecompress kept.
+	Recompression Recompression
+	// Scans are the scans of the output, several for a progressive one. It's nil if the output couldn't be parsed.
+	Scans []ScanInfo
+}
+
+// NewCJpeg creates new CJpeg instance using DefaultRunner
 func NewCJpeg() (*CJpeg, error) {
-	binWrapper, err := createBinWrapper("cjpeg")
+	runner, err := defaultRunnerFor("cjpeg")
 	if err != nil {
-		return nil, fmt.Errorf("failed to create bin wrapper: %v", err)
+		return nil, err
 	}
 
-	bin := &CJpeg{
-		BinWrapper: binWrapper,
+	return NewCJpegWithRunner(runner), nil
+}
+
+// NewCJpegWithRunner creates new CJpeg instance running cjpeg with runner
+func NewCJpegWithRunner(runner Runner) *CJpeg {
+	return &CJpeg{
+		BinWrapper: embeddedBinWrapper(runner, "cjpeg"),
+		Runner:     runner,
 		quality:    -1,
+		dcScanOpt:  -1,
+		keepICC:    true,
 	}
+}
 
-	return bin, nil
+// runner returns the Runner to run cjpeg with, honoring BinWrapper.
+func (c *CJpeg) runner() Runner {
+	return withBinWrapper(c.Runner, "cjpeg", c.BinWrapper)
 }
 
 // InputFile sets image file to convert.
@@ -47,6 +104,10 @@ func (c *CJpeg) InputFile(file string) *CJpeg {
 
 // Input sets reader to convert.
 // InputFile or InputImage called before will be ignored.
+//
+// The format is recognized from the first bytes. JPEG, PPM, PGM, BMP and Targa data is passed to cjpeg as is;
+// PNG, GIF (first frame), WebP and TIFF data is decoded in Go and composited over the Background first.
+// Run fails with an *UnsupportedFormatError for other formats. The same applies to InputFile.
 func (c *CJpeg) Input(reader io.Reader) *CJpeg {
 	c.inputFile = ""
 	c.inputImage = nil
@@ -56,6 +117,10 @@ func (c *CJpeg) Input(reader io.Reader) *CJpeg {
 
 // InputImage sets image to convert.
 // InputFile or Input called before will be ignored.
+//
+// An *image.YCbCr keeps its subsampling exactly, whatever Subsample says, and is compressed from its planes
+// without a round trip through RGB: by the Runner if it compresses planes itself (like CgoRunner does),
+// by cjpeg otherwise.
 func (c *CJpeg) InputImage(img image.Image) *CJpeg {
 	c.inputFile = ""
 	c.input = nil
@@ -88,6 +153,7 @@ func (c *CJpeg) Quality(quality uint) *CJpeg {
 	}
 
 	c.quality = int(quality)
+	c.checkToolchain()
 	return c
 }
 
@@ -97,19 +163,158 @@ func (c *CJpeg) Quality(quality uint) *CJpeg {
 // Image quality and speed of decompression are unaffected by Optimize.
 func (c *CJpeg) Optimize(optimize bool) *CJpeg {
 	c.optimize = optimize
+	c.checkToolchain()
+	return c
+}
+
+// Baseline creates a baseline JPEG file instead of the progressive one mozjpeg creates by default.
+func (c *CJpeg) Baseline(baseline bool) *CJpeg {
+	c.baseline = baseline
+	c.checkToolchain()
+	return c
+}
+
+// Revert uses the standard libjpeg defaults instead of mozjpeg's: baseline coding with the standard Huffman tables
+// and quantization tables from Annex K, without trellis quantization. Other switches still apply on top of them.
+func (c *CJpeg) Revert(revert bool) *CJpeg {
+	c.revert = revert
+	c.checkToolchain()
+	return c
+}
+
+// QuantTables sets the quantization tables, in natural order: the first one is used for luma and the second one,
+// if given, for chroma. Values are clamped to 1..255. Quality scales them like with cjpeg -qtables;
+// without Quality they're used as given.
+// They don't apply to images compressed from YCbCr planes. QuantTables() restores the default tables.
+func (c *CJpeg) QuantTables(tables ...[64]int) *CJpeg {
+	c.quantTables = tables
+	c.checkToolchain()
+	return c
+}
+
+// Arithmetic uses arithmetic coding instead of Huffman coding.
+// Arithmetic coded files are smaller, but many decoders can't read them.
+func (c *CJpeg) Arithmetic(arithmetic bool) *CJpeg {
+	c.arithmetic = arithmetic
+	c.checkToolchain()
+	return c
+}
+
+// DCScanOpt sets the DC scan optimization mode of progressive files:
+// 0 puts all components in one DC scan, 1 uses one DC scan per component (the default) and 2 optimizes between them.
+func (c *CJpeg) DCScanOpt(mode uint) *CJpeg {
+	c.dcScanOpt = int(min(mode, 2))
+	c.checkToolchain()
+	return c
+}
+
+// Subsample sets chroma subsampling of color images. The default is 4:2:0.
+// Ratios other than 4:4:4, 4:2:2, 4:2:0, 4:4:0, 4:1:1 and 4:1:0 are ignored.
+func (c *CJpeg) Subsample(ratio image.YCbCrSubsampleRatio) *CJpeg {
+	h, v, err := samplingFactors(ratio)
+	if err != nil {
+		c.sample = ""
+		c.checkToolchain()
+		return c
+	}
+
+	c.sample = fmt.Sprintf("%dx%d", h, v)
+	c.checkToolchain()
+	return c
+}
+
+// Metadata copies the metadata of a JPEG input selected by policy to the output. nil, the default, copies nothing.
+func (c *CJpeg) Metadata(policy *MetadataPolicy) *CJpeg {
+	c.metadata = copyPolicy(policy)
+	return c
+}
+
+// Background sets the color transparent images set with InputImage are composited over. The default is white.
+// Compositing happens in linear light, so that edges of transparent areas don't get dark halos.
+func (c *CJpeg) Background(bg color.Color) *CJpeg {
+	c.background = bg
+	return c
+}
+
+// Resize scales the input down to fit within maxWidth x maxHeight before encoding, keeping its aspect ratio.
+// A zero dimension doesn't constrain the image, and Resize(0, 0, nil) disables resizing.
+// filter is the resampling kernel, like Lanczos or draw.CatmullRom (the default when nil).
+// ResizeMode selects how the image is fitted into the box.
+//
+// Resampling happens in linear light. Resized inputs are decoded in Go, so Targa inputs can't be resized,
+// and an *image.YCbCr set with InputImage loses its subsampling. JPEG inputs at least twice as large as needed
+// are first decoded by djpeg at a reduced scale (-scale M/8), which is much faster than decoding them at full size.
+// SmartRecompress doesn't apply to resized inputs.
+func (c *CJpeg) Resize(maxWidth, maxHeight int, filter *draw.Kernel) *CJpeg {
+	c.resize.Width = max(maxWidth, 0)
+	c.resize.Height = max(maxHeight, 0)
+	c.resize.Filter = filter
+	return c
+}
+
+// ResizeMode sets how the input is fitted into the box given to Resize. The default is ResizeFit.
+func (c *CJpeg) ResizeMode(mode ResizeMode) *CJpeg {
+	c.resize.Mode = mode
+	return c
+}
+
+// resizing reports whether the input is resized before encoding.
+func (c *CJpeg) resizing() bool {
+	return c.resize.Width > 0 || c.resize.Height > 0
+}
+
+// KeepICC keeps the ICC profile of a JPEG or PNG input in the output. It's enabled by default.
+// When disabled, the output has no profile unless one is set with ICCProfile.
+// With a Metadata policy, the profile of a JPEG input is kept only if the policy keeps it.
+func (c *CJpeg) KeepICC(keep bool) *CJpeg {
+	c.keepICC = keep
 	return c
 }
 
+// ICCProfile embeds profile into the output, replacing the profile of the input if there is one.
+// Images set with InputImage carry no profile, so this is the way to keep theirs. nil restores the default.
+func (c *CJpeg) ICCProfile(profile []byte) *CJpeg {
+	c.iccProfile = profile
+	return c
+}
+
+// Toolchain makes CJpeg check that the cjpeg described by t supports the switches selected by the builder methods,
+// like Arithmetic or DCScanOpt. The first unsupported one is reported by Err, and makes Run fail without running anything.
+// nil, the default, disables the checks.
+func (c *CJpeg) Toolchain(t *Toolchain) *CJpeg {
+	c.toolchain = t
+	c.checkToolchain()
+	return c
+}
+
+// Err returns the error Run would fail with because of the options set, like an *UnsupportedFeatureError.
+func (c *CJpeg) Err() error {
+	return c.err
+}
+
+func (c *CJpeg) checkToolchain() {
+	c.err = nil
+	if c.toolchain != nil {
+		flags := switches(c.args(c.quality))
+		if len(c.quantTables) > 0 {
+			flags = append(flags, "-qtables")
+		}
+
+		c.err = c.toolchain.check("cjpeg", flags...)
+	}
+}
+
 // Run starts cjpeg with specified parameters.
 func (c *CJpeg) Run() error {
-	defer c.BinWrapper.Reset()
+	return c.RunContext(context.Background())
+}
 
-	if c.quality > -1 {
-		c.BinWrapper.Arg("-quality", fmt.Sprintf("%d", c.quality))
-	}
+// RunContext starts cjpeg with specified parameters. cjpeg is stopped when ctx is done.
+func (c *CJpeg) RunContext(ctx context.Context) error {
+	c.result = nil
 
-	if c.optimize {
-		c.BinWrapper.Arg("-optimize")
+	if c.err != nil {
+		return c.err
 	}
 
 	output, err := c.getOutput()
@@ -118,61 +323,590 @@ func (c *CJpeg) Run() error {
 		return err
 	}
 
-	if output != "" {
-		c.BinWrapper.Arg("-outfile", output)
+	in, err := c.newInput(ctx)
+
+	if err != nil {
+		return err
 	}
 
-	err = c.setInput()
+	icc, err := c.outputICC(in)
 
 	if err != nil {
 		return err
 	}
 
+	if c.targetSize > 0 || c.target != nil || c.smart || c.metadata != nil || icc != nil || !c.keepICC {
+		if err := in.replayable(); err != nil {
+			return err
+		}
+
+		data, result, err := c.encodeBuffered(ctx, in, icc)
+
+		if err != nil {
+			return err
+		}
+
+		result.Scans = indexScans(data)
+
+		c.result = result
+		return c.writeOutput(data)
+	}
+
+	var counter *countingWriter
+	var idx scanIndexer
+	var stdout io.Writer
 	if c.output != nil {
-		c.BinWrapper.SetStdOut(c.output)
+		counter = &countingWriter{w: c.output}
+		stdout = io.MultiWriter(counter, &idx)
 	}
 
-	err = c.BinWrapper.Run()
+	err = c.encode(ctx, c.quality, in, stdout, output)
 
 	if err != nil {
-		return errors.New(err.Error() + ". " + string(c.BinWrapper.StdErr()))
+		return err
+	}
+
+	c.result = &EncodeResult{Quality: c.quality, Trials: 1}
+
+	if counter != nil {
+		c.result.Size = counter.n
+		c.result.Scans, _ = idx.result()
+	} else if info, err := os.Stat(output); err == nil {
+		c.result.Size = int(info.Size())
+		c.result.Scans, _ = scanFileIndex(output)
+	}
+
+	if c.result.Quality < 0 {
+		c.result.Quality = defaultQuality
 	}
 
 	return nil
 }
 
+// Result returns the outcome of the last successful Run, or nil.
+func (c *CJpeg) Result() *EncodeResult {
+	return c.result
+}
+
+// postProcess applies the MetadataPolicy and embeds icc into data encoded from in.
+func (c *CJpeg) postProcess(in *cjpegInput, data, icc []byte) ([]byte, error) {
+	if c.metadata != nil {
+		var source []byte
+		if c.inputImage == nil {
+			var err error
+			if source, err = in.bytes(); err != nil {
+				return nil, err
+			}
+		}
+
+		var err error
+		if data, err = applyMetadata(c.metadata, source, data); err != nil {
+			return nil, err
+		}
+	}
+
+	if icc == nil && c.keepICC {
+		return data, nil
+	}
+
+	// Without a profile to keep, the one cjpeg may have copied from a JPEG input is removed.
+	return setICC(data, icc)
+}
+
+// outputICC returns the ICC profile to embed into the output, nil if there is none.
+// Only the header of a JPEG input passed to cjpeg as is is read. PNM, BMP and Targa inputs have no profile.
+func (c *CJpeg) outputICC(in *cjpegInput) ([]byte, error) {
+	if c.iccProfile != nil {
+		return c.iccProfile, nil
+	}
+
+	if !c.keepICC || c.inputImage != nil {
+		return nil, nil
+	}
+
+	if in.source == nil && (!in.jpeg || c.metadata != nil) {
+		return nil, nil
+	}
+
+	if isJPEG(in.source) && c.metadata != nil {
+		// The MetadataPolicy keeps the profile of a JPEG input or drops it.
+		return nil, nil
+	}
+
+	// A damaged profile is dropped rather than failing the run.
+	var icc []byte
+	var err error
+	if in.source != nil {
+		icc, err = extractICC(in.source)
+	} else {
+		var segments []segment
+		if segments, err = in.header(); err == nil {
+			icc, err = jpegICC(segments)
+		}
+	}
+
+	if err != nil {
+		return nil, nil
+	}
+
+	return icc, nil
+}
+
+// encodeBuffered encodes in to memory, searching for the quality if TargetSize or TargetQuality is set,
+// and following SmartRecompress. The output is post-processed with icc, so that TargetSize accounts
+// for the metadata and the profile added to it.
+// in must be replayable for the search.
+func (c *CJpeg) encodeBuffered(ctx context.Context, in *cjpegInput, icc []byte) ([]byte, *EncodeResult, error) {
+	if c.smart {
+		data, result, ok, err := c.recompress(ctx, in, icc)
+		if ok {
+			return data, result, err
+		}
+	}
+
+	if c.target != nil {
+		data, result, err := c.runTargetQuality(ctx, in)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if data, err = c.postProcess(in, data, icc); err != nil {
+			return nil, nil, err
+		}
+
+		result.Size = len(data)
+		return data, result, nil
+	}
+
+	if c.targetSize > 0 {
+		data, quality, trials, err := searchQuality(c.targetSize, 0, c.maxQuality(), func(quality int) ([]byte, error) {
+			data, err := c.encodeBytes(ctx, quality, in)
+			if err != nil {
+				return nil, err
+			}
+
+			return c.postProcess(in, data, icc)
+		})
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return data, &EncodeResult{Quality: quality, Size: len(data), Trials: trials}, nil
+	}
+
+	data, err := c.encodeBytes(ctx, c.quality, in)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if data, err = c.postProcess(in, data, icc); err != nil {
+		return nil, nil, err
+	}
+
+	quality := c.quality
+	if quality < 0 {
+		quality = defaultQuality
+	}
+
+	return data, &EncodeResult{Quality: quality, Size: len(data), Trials: 1}, nil
+}
+
+// encode runs cjpeg once with quality, writing to stdout or to outfile.
+// A nil stdout is passed on as is, so that the runner collects standard output.
+func (c *CJpeg) encode(ctx context.Context, quality int, in *cjpegInput, stdout io.Writer, outfile string) error {
+	if in.ycbcr != nil {
+		return c.encodeYCbCr(ctx, in.ycbcr, quality, stdout, outfile)
+	}
+
+	inv := &Invocation{Tool: "cjpeg", Args: c.args(quality)}
+	if in.sample != "" {
+		// The planes are passed as R, G and B, which cjpeg -rgb compresses without any color transform.
+		inv.Args = append(inv.Args, "-rgb", "-sample", in.sample, "-qslots", "0,1,1")
+	}
+
+	if len(c.quantTables) > 0 {
+		name, err := writeQuantTables(c.quantTables)
+		if err != nil {
+			return err
+		}
+
+		defer os.Remove(name)
+		inv.Args = append(inv.Args, "-qtables", name)
+	}
+
+	if in.sample != "" {
+		var out bytes.Buffer
+		in.apply(inv)
+		inv.Stdout = &out
+
+		if _, err := runTool(ctx, c.runner(), inv); err != nil {
+			return err
+		}
+
+		data, err := relabelYCbCr(out.Bytes())
+		if err != nil {
+			return err
+		}
+
+		return writeTo(data, stdout, outfile)
+	}
+
+	if outfile != "" {
+		inv.Args = append(inv.Args, "-outfile", outfile)
+	}
+
+	in.apply(inv)
+	inv.Stdout = stdout
+
+	_, err := runTool(ctx, c.runner(), inv)
+	return err
+}
+
+// encodeBytes runs cjpeg once with quality and returns its output.
+func (c *CJpeg) encodeBytes(ctx context.Context, quality int, in *cjpegInput) ([]byte, error) {
+	var out bytes.Buffer
+	err := c.encode(ctx, quality, in, &out, "")
+	return out.Bytes(), err
+}
+
+// args returns cjpeg switches for the configured parameters.
+func (c *CJpeg) args(quality int) []string {
+	var args []string
+
+	if c.revert {
+		args = append(args, "-revert")
+	}
+
+	// Tables given with -qtables are scaled by the quality, 50 leaves them unchanged.
+	if quality < 0 && len(c.quantTables) > 0 {
+		quality = 50
+	}
+
+	if quality > -1 {
+		args = append(args, "-quality", fmt.Sprintf("%d", quality))
+	}
+
+	if c.optimize {
+		args = append(args, "-optimize")
+	}
+
+	if c.baseline {
+		args = append(args, "-baseline")
+	}
+
+	if c.arithmetic {
+		args = append(args, "-arithmetic")
+	}
+
+	if c.dcScanOpt > -1 {
+		args = append(args, "-dc-scan-opt", fmt.Sprintf("%d", c.dcScanOpt))
+	}
+
+	if c.sample != "" {
+		args = append(args, "-sample", c.sample)
+	}
+
+	return args
+}
+
+// writeQuantTables writes tables to a temporary file in the format read by cjpeg -qtables and returns its name.
+func writeQuantTables(tables [][64]int) (string, error) {
+	f, err := os.CreateTemp("", "mozjpegbin-qtables-*.txt")
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	for _, table := range tables {
+		for i, v := range table {
+			fmt.Fprintf(&buf, "%d", min(max(v, 1), 255))
+			if i%8 == 7 {
+				buf.WriteByte('\n')
+			} else {
+				buf.WriteByte(' ')
+			}
+		}
+	}
+
+	_, err = f.Write(buf.Bytes())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+// planar reports whether *image.YCbCr inputs are compressed from their planes:
+// by a Runner compressing them itself, or in Go when cjpeg is skipped.
+func (c *CJpeg) planar() bool {
+	_, ok := c.Runner.(ycbcrEncoder)
+	return ok || c.skipCJpeg()
+}
+
+// ycbcrEncoderOptions reports whether a ycbcrEncoder can follow the options set on c, as it only takes the quality.
+// Other options are left to cjpeg.
+func (c *CJpeg) ycbcrEncoderOptions() bool {
+	return len(c.quantTables) == 0 && !c.revert && !c.baseline && !c.arithmetic && c.dcScanOpt < 0
+}
+
+// newYCbCrInput returns the input passing the planes of img to cjpeg in place of RGB, with chroma repeated over
+// the pixels it covers. cjpeg averages it back to the same samples, keeping the subsampling of img.
+func newYCbCrInput(img *image.YCbCr) (*cjpegInput, error) {
+	h, v, err := samplingFactors(img.SubsampleRatio)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	buf := bytes.NewBufferString(fmt.Sprintf("P6\n%d %d\n255\n", width, bounds.Dy()))
+	buf.Grow(width * bounds.Dy() * 3)
+	row := make([]byte, width*3)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := 0; x < width; x++ {
+			yi, ci := img.YOffset(bounds.Min.X+x, y), img.COffset(bounds.Min.X+x, y)
+			row[x*3], row[x*3+1], row[x*3+2] = img.Y[yi], img.Cb[ci], img.Cr[ci]
+		}
+
+		buf.Write(row)
+	}
+
+	return &cjpegInput{data: buf.Bytes(), image: img, sample: fmt.Sprintf("%dx%d", h, v)}, nil
+}
+
+// encodeYCbCr compresses YCbCr planes keeping their subsampling.
+// Subsamplings and options the Runner can't handle are left to cjpeg.
+func (c *CJpeg) encodeYCbCr(ctx context.Context, img *image.YCbCr, quality int, stdout io.Writer, outfile string) error {
+	if enc, ok := c.Runner.(ycbcrEncoder); ok {
+		if c.ycbcrEncoderOptions() {
+			data, err := enc.encodeYCbCr(img, quality)
+			if err == nil {
+				return writeTo(data, stdout, outfile)
+			}
+
+			if !errors.Is(err, errUnsupportedSubsampling) {
+				return err
+			}
+		}
+
+		in, err := newYCbCrInput(img)
+		if err != nil {
+			return err
+		}
+
+		return c.encode(ctx, quality, in, stdout, outfile)
+	}
+
+	if err := c.checkSkipCJpeg(); err != nil {
+		return err
+	}
+
+	var baseline bytes.Buffer
+	if err := encodeYCbCrBaseline(&baseline, img, quality); err != nil {
+		return err
+	}
+
+	inv := &Invocation{
+		Tool:   "jpegtran",
+		Args:   []string{"-optimize", "-copy", "none"},
+		Stdin:  &baseline,
+		Stdout: stdout,
+	}
+
+	if c.baseline {
+		// jpegtran makes progressive files unless it's reverted to the libjpeg defaults.
+		inv.Args = append([]string{"-revert"}, inv.Args...)
+	}
+
+	if outfile != "" {
+		inv.Args = append(inv.Args, "-outfile", outfile)
+	}
+
+	_, err := runTool(ctx, c.runner(), inv)
+	return err
+}
+
+// writeOutput writes data to Output or OutputFile.
+func (c *CJpeg) writeOutput(data []byte) error {
+	output, err := c.getOutput()
+
+	if err != nil {
+		return err
+	}
+
+	if c.output != nil {
+		return writeTo(data, c.output, "")
+	}
+
+	return writeTo(data, nil, output)
+}
+
 // Version returns cjpeg version.
 func (c *CJpeg) Version() (string, error) {
-	return version(c.BinWrapper)
+	return version(c.runner(), "cjpeg")
 }
 
 // Reset resets all parameters to default values
 func (c *CJpeg) Reset() *CJpeg {
 	c.quality = -1
 	c.optimize = false
+	c.baseline = false
+	c.arithmetic = false
+	c.dcScanOpt = -1
+	c.sample = ""
+	c.revert = false
+	c.quantTables = nil
+	c.smart = false
+	c.minSaving = 0
+	c.metadata = nil
+	c.keepICC = true
+	c.iccProfile = nil
+	c.background = nil
+	c.resize = Resize{}
+	c.targetSize = 0
+	c.target = nil
+	c.concurrency = 0
+	c.checkToolchain()
 	return c
 }
 
-func (c *CJpeg) setInput() error {
+// cjpegInput is the input of a run, prepared so that it can be fed to cjpeg several times if needed.
+type cjpegInput struct {
+	file   string
+	reader io.Reader
+	data   []byte
+	ycbcr  *image.YCbCr
+	// sample is set when data holds the planes of an *image.YCbCr. It's the -sample keeping their subsampling.
+	sample string
+	// image is the image set with InputImage or decoded from the input, composited over the background.
+	image image.Image
+	// source is the content of an input converted before being passed to cjpeg.
+	source []byte
+	// jpeg is set for JPEG inputs passed to cjpeg as is.
+	jpeg bool
+}
+
+func (c *CJpeg) newInput(ctx context.Context) (*cjpegInput, error) {
 	if c.input != nil {
-		c.BinWrapper.StdIn(c.input)
-	} else if c.inputImage != nil {
-		r, err := createReaderFromImage(c.inputImage)
+		format, reader, err := sniffReader(c.input)
 
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		c.BinWrapper.StdIn(r)
+		if format.native && !c.skipCJpeg() && !c.resizing() {
+			return &cjpegInput{reader: reader, jpeg: format.name == formatJPEG.name}, nil
+		}
+
+		data, err := io.ReadAll(reader)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return c.convertInput(ctx, format, data)
+	} else if ycbcr, ok := c.inputImage.(*image.YCbCr); ok && !c.resizing() {
+		if c.planar() {
+			return &cjpegInput{ycbcr: ycbcr}, nil
+		}
+
+		return newYCbCrInput(ycbcr)
+	} else if c.inputImage != nil {
+		return c.imageInput(resizeImage(c.inputImage, c.background, &c.resize), nil)
 	} else if c.inputFile != "" {
-		c.BinWrapper.Arg(c.inputFile)
-	} else {
-		return errors.New("undefined input")
+		format, err := sniffFile(c.inputFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if format.native && !c.skipCJpeg() && !c.resizing() {
+			return &cjpegInput{file: c.inputFile, jpeg: format.name == formatJPEG.name}, nil
+		}
+
+		data, err := os.ReadFile(c.inputFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return c.convertInput(ctx, format, data)
+	}
+
+	return nil, errors.New("undefined input")
+}
+
+// replayable reads a streamed input into memory, so that apply can be called more than once.
+func (in *cjpegInput) replayable() error {
+	if in.reader == nil {
+		return nil
+	}
+
+	data, err := io.ReadAll(in.reader)
+
+	if err != nil {
+		return err
 	}
 
+	in.data = data
+	in.reader = nil
 	return nil
 }
 
+// header reads the segments before the first scan of a JPEG input passed to cjpeg as is.
+// A streamed input is read no further and stays complete.
+func (in *cjpegInput) header() ([]segment, error) {
+	if in.data != nil {
+		segments, _, err := splitHeader(in.data)
+		return segments, err
+	}
+
+	if in.reader != nil {
+		var head bytes.Buffer
+		segments, err := readHeader(io.TeeReader(in.reader, &head))
+		in.reader = io.MultiReader(&head, in.reader)
+		return segments, err
+	}
+
+	f, err := os.Open(in.file)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	return readHeader(bufio.NewReader(f))
+}
+
+// bytes returns the content of the input, before conversion. in must be replayable.
+func (in *cjpegInput) bytes() ([]byte, error) {
+	if in.source != nil {
+		return in.source, nil
+	} else if in.file != "" {
+		return os.ReadFile(in.file)
+	}
+
+	return in.data, nil
+}
+
+func (in *cjpegInput) apply(inv *Invocation) {
+	if in.reader != nil {
+		inv.Stdin = in.reader
+	} else if in.data != nil {
+		inv.Stdin = bytes.NewReader(in.data)
+	} else if in.file != "" {
+		inv.Args = append(inv.Args, in.file)
+	}
+}
+
 func (c *CJpeg) getOutput() (string, error) {
 	if c.output != nil {
 		return "", nil
@@ -1,6 +1,7 @@
 package mozjpegbin_test
 
 import (
+	"bytes"
 	"fmt"
 	"image"
 	"image/color"
@@ -95,6 +96,59 @@ func TestEncodeImage2(t *testing.T) {
 	validateJpgImage(t, img)
 }
 
+func TestEncodeImageFidelity(t *testing.T) {
+	// Alternating red and blue columns only survive with full-resolution
+	// chroma and no lossy intermediate between the image and cjpeg.
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			if x%2 == 0 {
+				img.Set(x, y, color.RGBA{R: 255, A: 255})
+			} else {
+				img.Set(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+
+	c, err := mozjpegbin.NewCJpeg()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var out bytes.Buffer
+	err = c.Quality(100).
+		Subsample(image.YCbCrSubsampleRatio444).
+		InputImage(img).
+		Output(&out).
+		Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	decoded, err := jpeg.Decode(&out)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var sum float64
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			r1, g1, b1, _ := img.At(x, y).RGBA()
+			r2, g2, b2, _ := decoded.At(x, y).RGBA()
+			for _, d := range []float64{
+				float64(r1>>8) - float64(r2>>8),
+				float64(g1>>8) - float64(g2>>8),
+				float64(b1>>8) - float64(b2>>8),
+			} {
+				sum += d * d
+			}
+		}
+	}
+
+	assert.Less(t, sum/(64*64*3), 4.0)
+}
+
 func TestEncodeReader(t *testing.T) {
 	c, err := mozjpegbin.NewCJpeg()
 	if !assert.Nil(t, err) {
@@ -190,3 +244,29 @@ func validateJpgImage(t *testing.T, imgSource image.Image) {
 	}
 	assert.Equal(t, imgSource.Bounds(), imgTarget.Bounds())
 }
+
+func TestEncodeBinWrapper(t *testing.T) {
+	c, err := mozjpegbin.NewCJpeg()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	if _, ok := c.Runner.(*mozjpegbin.EmbeddedRunner); !ok {
+		t.Skip("BinWrapper is only set with the EmbeddedRunner")
+	}
+
+	c.BinWrapper.Arg("-grayscale")
+	img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	var buf bytes.Buffer
+	err = c.InputImage(img).Output(&buf).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	info, err := mozjpegbin.Inspect(&buf)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Len(t, info.Components, 1)
+}
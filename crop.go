@@ -0,0 +1,171 @@
+package mozjpegbin
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+)
+
+// ErrCropOutOfBounds is returned when a crop isn't within the image.
+var ErrCropOutOfBounds = errors.New("crop out of bounds")
+
+// Gravity tells where a crop of a given size or aspect ratio is placed in the image.
+type Gravity int
+
+const (
+	GravityCenter Gravity = iota
+	GravityNorth
+	GravitySouth
+	GravityEast
+	GravityWest
+	// GravityFocus centers the crop on CropSpec.Focus, like a detected face, as far as the image allows.
+	GravityFocus
+)
+
+// CropSpec describes a crop to plan with PlanCrop.
+// Rect selects an explicit rectangle. Otherwise Size, or else the largest rectangle of the aspect ratio
+// AspectWidth:AspectHeight, is placed according to Gravity.
+type CropSpec struct {
+	Rect image.Rectangle
+	Size image.Point
+	// AspectWidth and AspectHeight are the aspect ratio of the crop, like 16 and 9.
+	AspectWidth  int
+	AspectHeight int
+	Gravity      Gravity
+	// Focus is the box the crop is centered on with GravityFocus.
+	Focus image.Rectangle
+}
+
+// CropPlan is the outcome of PlanCrop.
+type CropPlan struct {
+	// Image is the rectangle of the source image.
+	Image image.Rectangle
+	// IMCU is the size of the iMCUs of the source. jpegtran moves the origin of crops to the iMCU boundary on its top left.
+	IMCU image.Point
+	// Requested is the rectangle described by the CropSpec. Crops placed by Gravity have their origin on an iMCU boundary.
+	Requested image.Rectangle
+	// Rect is the rectangle jpegtran produces: Requested, extended to the iMCU boundary on its top left.
+	Rect image.Rectangle
+}
+
+// Exact reports whether the crop produces exactly the requested rectangle.
+func (p *CropPlan) Exact() bool {
+	return p.Rect == p.Requested
+}
+
+// PlanCrop reads a JPEG file from r and plans a lossless crop of it following spec.
+// It returns an error wrapping ErrCropOutOfBounds if the crop isn't within the image.
+func PlanCrop(r io.Reader, spec CropSpec) (*CropPlan, error) {
+	info, err := Inspect(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return info.PlanCrop(spec)
+}
+
+// IMCU returns the size of the iMCUs of the file, 8x8 blocks times the largest sampling factors.
+// It's 8x8 for grayscale files, whatever their sampling factors, like jpegtran does.
+func (info *Info) IMCU() image.Point {
+	if len(info.Components) == 1 {
+		return image.Pt(8, 8)
+	}
+
+	h, v := 1, 1
+	for _, c := range info.Components {
+		h, v = max(h, c.H), max(v, c.V)
+	}
+
+	return image.Pt(8*h, 8*v)
+}
+
+// PlanCrop plans a lossless crop of the file described by info following spec. See PlanCrop.
+func (info *Info) PlanCrop(spec CropSpec) (*CropPlan, error) {
+	plan := &CropPlan{Image: image.Rect(0, 0, info.Width, info.Height), IMCU: info.IMCU()}
+
+	var size image.Point
+	switch {
+	case !spec.Rect.Empty():
+		plan.Requested = spec.Rect
+	case spec.Size.X > 0 && spec.Size.Y > 0:
+		size = spec.Size
+	case spec.AspectWidth > 0 && spec.AspectHeight > 0:
+		size = image.Pt(info.Width, info.Width*spec.AspectHeight/spec.AspectWidth)
+		if size.Y > info.Height {
+			size = image.Pt(info.Height*spec.AspectWidth/spec.AspectHeight, info.Height)
+		}
+	default:
+		return nil, errors.New("crop needs a rectangle, a size or an aspect ratio")
+	}
+
+	if plan.Requested.Empty() {
+		if size.X > info.Width || size.Y > info.Height || size.X <= 0 || size.Y <= 0 {
+			return nil, fmt.Errorf("%w: %dx%d crop of %dx%d image", ErrCropOutOfBounds, size.X, size.Y, info.Width, info.Height)
+		}
+
+		origin, err := spec.place(plan.Image, size, plan.IMCU)
+		if err != nil {
+			return nil, err
+		}
+
+		plan.Requested = image.Rectangle{Min: origin, Max: origin.Add(size)}
+	}
+
+	if !plan.Requested.In(plan.Image) {
+		return nil, fmt.Errorf("%w: %v crop of %dx%d image", ErrCropOutOfBounds, plan.Requested, info.Width, info.Height)
+	}
+
+	origin := image.Pt(plan.Requested.Min.X/plan.IMCU.X*plan.IMCU.X, plan.Requested.Min.Y/plan.IMCU.Y*plan.IMCU.Y)
+	plan.Rect = image.Rectangle{Min: origin, Max: plan.Requested.Max}
+	return plan, nil
+}
+
+// place returns the origin of a crop of size within bounds following the gravity, aligned on imcu.
+func (spec *CropSpec) place(bounds image.Rectangle, size, imcu image.Point) (image.Point, error) {
+	free := bounds.Size().Sub(size)
+	center := image.Pt(free.X/2, free.Y/2)
+
+	var origin image.Point
+	switch spec.Gravity {
+	case GravityCenter:
+		origin = center
+	case GravityNorth:
+		origin = image.Pt(center.X, 0)
+	case GravitySouth:
+		origin = image.Pt(center.X, free.Y)
+	case GravityEast:
+		origin = image.Pt(free.X, center.Y)
+	case GravityWest:
+		origin = image.Pt(0, center.Y)
+	case GravityFocus:
+		if spec.Focus.Empty() || !spec.Focus.Overlaps(bounds) {
+			return image.Point{}, errors.New("GravityFocus needs a focus box within the image")
+		}
+
+		focus := spec.Focus.Intersect(bounds)
+		mid := focus.Min.Add(focus.Max).Div(2)
+		origin = mid.Sub(size.Div(2))
+	default:
+		return image.Point{}, fmt.Errorf("invalid gravity %d", spec.Gravity)
+	}
+
+	return image.Pt(alignOrigin(origin.X, free.X, imcu.X), alignOrigin(origin.Y, free.Y, imcu.Y)), nil
+}
+
+// alignOrigin returns the multiple of step in [0, limit] closest to pos, or 0 if there is none but 0.
+func alignOrigin(pos, limit, step int) int {
+	pos = min(max(pos, 0), limit)
+	down := pos / step * step
+	if up := down + step; up <= limit && up-pos < pos-down {
+		return up
+	}
+
+	return down
+}
+
+// CropPlan crops to the rectangle planned by PlanCrop. Crop called before will be ignored.
+func (c *JpegTran) CropPlan(plan *CropPlan) *JpegTran {
+	r := plan.Rect
+	return c.Crop(r.Min.X, r.Min.Y, r.Dx(), r.Dy())
+}
@@ -0,0 +1,166 @@
+package mozjpegbin_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"os"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanCropRect(t *testing.T) {
+	f, err := os.Open("source.jpg")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer f.Close()
+
+	plan, err := mozjpegbin.PlanCrop(f, mozjpegbin.CropSpec{Rect: image.Rect(500, 500, 600, 600)})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, image.Rect(0, 0, 1203, 901), plan.Image)
+	assert.Equal(t, image.Pt(16, 16), plan.IMCU)
+	assert.Equal(t, image.Rect(500, 500, 600, 600), plan.Requested)
+	assert.Equal(t, image.Rect(496, 496, 600, 600), plan.Rect)
+	assert.False(t, plan.Exact())
+
+	var out bytes.Buffer
+	c, err := mozjpegbin.NewJpegTran()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	err = c.CropPlan(plan).InputFile("source.jpg").Output(&out).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	info, err := mozjpegbin.Inspect(&out)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, plan.Rect.Dx(), info.Width)
+	assert.Equal(t, plan.Rect.Dy(), info.Height)
+}
+
+func TestPlanCropGravity(t *testing.T) {
+	info := sourceInfo(t)
+
+	tests := []struct {
+		name string
+		spec mozjpegbin.CropSpec
+		rect image.Rectangle
+	}{
+		{"center", mozjpegbin.CropSpec{Size: image.Pt(400, 300)}, image.Rect(400, 304, 800, 604)},
+		{"north", mozjpegbin.CropSpec{Size: image.Pt(400, 300), Gravity: mozjpegbin.GravityNorth}, image.Rect(400, 0, 800, 300)},
+		{"south", mozjpegbin.CropSpec{Size: image.Pt(400, 300), Gravity: mozjpegbin.GravitySouth}, image.Rect(400, 592, 800, 892)},
+		{"east", mozjpegbin.CropSpec{Size: image.Pt(400, 300), Gravity: mozjpegbin.GravityEast}, image.Rect(800, 304, 1200, 604)},
+		{"west", mozjpegbin.CropSpec{Size: image.Pt(400, 300), Gravity: mozjpegbin.GravityWest}, image.Rect(0, 304, 400, 604)},
+		{"square", mozjpegbin.CropSpec{AspectWidth: 1, AspectHeight: 1}, image.Rect(144, 0, 1045, 901)},
+		{"wide", mozjpegbin.CropSpec{AspectWidth: 16, AspectHeight: 9, Gravity: mozjpegbin.GravityNorth}, image.Rect(0, 0, 1203, 676)},
+		{
+			"face",
+			mozjpegbin.CropSpec{AspectWidth: 1, AspectHeight: 1, Gravity: mozjpegbin.GravityFocus, Focus: image.Rect(100, 200, 200, 300)},
+			image.Rect(0, 0, 901, 901),
+		},
+		{
+			"face inside",
+			mozjpegbin.CropSpec{Size: image.Pt(200, 200), Gravity: mozjpegbin.GravityFocus, Focus: image.Rect(700, 400, 780, 500)},
+			image.Rect(640, 352, 840, 552),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan, err := info.PlanCrop(test.spec)
+			if !assert.Nil(t, err) {
+				t.FailNow()
+			}
+
+			assert.Equal(t, test.rect, plan.Rect)
+			assert.True(t, plan.Exact())
+			assert.Zero(t, plan.Rect.Min.X%plan.IMCU.X)
+			assert.Zero(t, plan.Rect.Min.Y%plan.IMCU.Y)
+		})
+	}
+}
+
+func TestPlanCropOutOfBounds(t *testing.T) {
+	info := sourceInfo(t)
+
+	for _, spec := range []mozjpegbin.CropSpec{
+		{Rect: image.Rect(1100, 800, 1300, 900)},
+		{Rect: image.Rect(-16, 0, 100, 100)},
+		{Size: image.Pt(1204, 100)},
+	} {
+		_, err := info.PlanCrop(spec)
+		assert.True(t, errors.Is(err, mozjpegbin.ErrCropOutOfBounds), "%v", spec)
+	}
+
+	_, err := info.PlanCrop(mozjpegbin.CropSpec{})
+	assert.NotNil(t, err)
+
+	_, err = info.PlanCrop(mozjpegbin.CropSpec{Size: image.Pt(100, 100), Gravity: mozjpegbin.GravityFocus})
+	assert.NotNil(t, err)
+}
+
+func TestPlanCropGray(t *testing.T) {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 100, 100)), nil)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	// Give the only component 2x2 sampling factors, which jpegtran ignores.
+	data := buf.Bytes()
+	sof := bytes.Index(data, []byte{0xFF, 0xC0})
+	if !assert.True(t, sof > 0) {
+		t.FailNow()
+	}
+	data[sof+11] = 0x22
+
+	plan, err := mozjpegbin.PlanCrop(bytes.NewReader(data), mozjpegbin.CropSpec{Rect: image.Rect(8, 8, 40, 40)})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, image.Pt(8, 8), plan.IMCU)
+	assert.True(t, plan.Exact())
+
+	var out bytes.Buffer
+	c, err := mozjpegbin.NewJpegTran()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	err = c.CropPlan(plan).Input(bytes.NewReader(data)).Output(&out).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	info, err := mozjpegbin.Inspect(&out)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, 32, info.Width)
+	assert.Equal(t, 32, info.Height)
+}
+
+func sourceInfo(t *testing.T) *mozjpegbin.Info {
+	f, err := os.Open("source.jpg")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer f.Close()
+
+	info, err := mozjpegbin.Inspect(f)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	return info
+}
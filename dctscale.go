@@ -0,0 +1,63 @@
+package mozjpegbin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+)
+
+// dctScale returns the numerator M of the strongest djpeg -scale M/8 reduction that keeps the part sr
+// of an image at least width x height pixels large. 8 means no reduction.
+func dctScale(sr image.Rectangle, width, height int) int {
+	m := 8
+	for m > 1 && ceilDiv(sr.Dx()*(m-1), 8) >= width && ceilDiv(sr.Dy()*(m-1), 8) >= height {
+		m--
+	}
+
+	return m
+}
+
+// scaleRect maps sr to an image reduced by m/8, within bounds.
+func scaleRect(sr image.Rectangle, m int, bounds image.Rectangle) image.Rectangle {
+	return image.Rect(sr.Min.X*m/8, sr.Min.Y*m/8, ceilDiv(sr.Max.X*m, 8), ceilDiv(sr.Max.Y*m, 8)).Intersect(bounds)
+}
+
+// decodeJPEGScaled decodes a JPEG input that is going to be resized.
+// When it's at least twice as large as needed, djpeg decodes it directly at a reduced scale, skipping most of
+// the inverse DCT and upsampling work, and the rest of the reduction is left to the resampling in Go.
+// It returns the decoded image, the part of it to resample and the size to resample it to,
+// or a nil image if the input should be decoded at full size.
+func (c *CJpeg) decodeJPEGScaled(ctx context.Context, data []byte) (image.Image, image.Rectangle, int, int, bool) {
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, image.Rectangle{}, 0, 0, false
+	}
+
+	sr, width, height, resize := c.resize.plan(image.Rect(0, 0, cfg.Width, cfg.Height))
+	m := dctScale(sr, width, height)
+	if !resize || m > 4 {
+		return nil, image.Rectangle{}, 0, 0, false
+	}
+
+	var pnm bytes.Buffer
+	inv := &Invocation{
+		Tool:   "djpeg",
+		Args:   []string{"-scale", fmt.Sprintf("%d/8", m), "-pnm"},
+		Stdin:  bytes.NewReader(data),
+		Stdout: &pnm,
+	}
+
+	// Inputs djpeg can't write as PNM, like CMYK ones, are decoded at full size instead.
+	if _, err := runTool(ctx, c.runner(), inv); err != nil {
+		return nil, image.Rectangle{}, 0, 0, false
+	}
+
+	img, err := readPNM(&pnm)
+	if err != nil {
+		return nil, image.Rectangle{}, 0, 0, false
+	}
+
+	return img, scaleRect(sr, m, img.Bounds()), width, height, true
+}
@@ -0,0 +1,106 @@
+package mozjpegbin_test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/Munchpass/go-mozjpegbin/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingRunner records the invocations passed to an EmbeddedRunner.
+type recordingRunner struct {
+	runner      *mozjpegbin.EmbeddedRunner
+	mu          sync.Mutex
+	invocations []mozjpegbin.Invocation
+}
+
+func (r *recordingRunner) Run(ctx context.Context, inv *mozjpegbin.Invocation) (*mozjpegbin.RunResult, error) {
+	r.mu.Lock()
+	r.invocations = append(r.invocations, *inv)
+	r.mu.Unlock()
+	return r.runner.Run(ctx, inv)
+}
+
+func TestCJpegResizeDCTScale(t *testing.T) {
+	r := &recordingRunner{runner: mozjpegbin.NewEmbeddedRunner()}
+
+	var out bytes.Buffer
+	err := mozjpegbin.NewCJpegWithRunner(r).
+		Quality(90).
+		Resize(150, 150, mozjpegbin.Lanczos).
+		InputFile("source.jpg").
+		Output(&out).
+		Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	if assert.Len(t, r.invocations, 2) {
+		assert.Equal(t, "djpeg", r.invocations[0].Tool)
+		assert.Equal(t, []string{"-scale", "1/8", "-pnm"}, r.invocations[0].Args)
+		assert.Equal(t, "cjpeg", r.invocations[1].Tool)
+	}
+
+	scaled, err := jpeg.Decode(&out)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, image.Rect(0, 0, 150, 112), scaled.Bounds())
+
+	// The output is close to the one resized from the input decoded at full size.
+	f, err := os.Open("source.jpg")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer f.Close()
+
+	full, err := jpeg.Decode(f)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var reference bytes.Buffer
+	err = mozjpegbin.NewCJpegWithRunner(mozjpegbin.NewEmbeddedRunner()).
+		Quality(90).
+		Resize(150, 150, mozjpegbin.Lanczos).
+		InputImage(full).
+		Output(&reference).
+		Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	ref, err := jpeg.Decode(&reference)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	result, err := metrics.Compare(ref, scaled)
+	if assert.Nil(t, err) {
+		assert.Greater(t, result.SSIM, 0.9)
+	}
+}
+
+func TestCJpegResizeSmallReduction(t *testing.T) {
+	r := &recordingRunner{runner: mozjpegbin.NewEmbeddedRunner()}
+
+	err := mozjpegbin.NewCJpegWithRunner(r).
+		Resize(1000, 0, nil).
+		InputFile("source.jpg").
+		Output(&bytes.Buffer{}).
+		Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	if assert.Len(t, r.invocations, 1) {
+		assert.Equal(t, "cjpeg", r.invocations[0].Tool)
+	}
+}
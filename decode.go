@@ -0,0 +1,42 @@
+package mozjpegbin
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"io"
+)
+
+// Decode decodes a JPEG image with mozjpeg.
+//
+// When built with the mozjpeg_cgo tag the image is decoded in-process,
+// otherwise djpeg is run with DefaultRunner.
+// Grayscale images are returned as *image.Gray, all others as *image.RGBA.
+func Decode(r io.Reader) (image.Image, error) {
+	if inProcess != nil {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+
+		return inProcess.decode(data)
+	}
+
+	return decodeWithRunner(context.Background(), DefaultRunner(), r)
+}
+
+func decodeWithRunner(ctx context.Context, runner Runner, r io.Reader) (image.Image, error) {
+	var out bytes.Buffer
+
+	_, err := runTool(ctx, runner, &Invocation{
+		Tool:   "djpeg",
+		Args:   []string{"-pnm"},
+		Stdin:  r,
+		Stdout: &out,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return readPNM(&out)
+}
@@ -0,0 +1,31 @@
+package mozjpegbin_test
+
+import (
+	"image/jpeg"
+	"os"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecode(t *testing.T) {
+	f, err := os.Open("source.jpg")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer f.Close()
+
+	img, err := mozjpegbin.Decode(f)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	f.Seek(0, 0)
+	expected, err := jpeg.Decode(f)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, expected.Bounds(), img.Bounds())
+}
@@ -8,8 +8,6 @@ import (
 	"os/exec"
 	"runtime"
 	"time"
-
-	"github.com/amenzhinsky/go-memexec"
 )
 
 /*
@@ -28,11 +26,12 @@ type EmbedBinWrapper struct {
 	stdIn        io.Reader
 	stdOutWriter io.Writer
 
-	args    []string
-	env     []string
-	debug   bool
-	cmd     *exec.Cmd
-	timeout time.Duration
+	args     []string
+	env      []string
+	debug    bool
+	cmd      *exec.Cmd
+	timeout  time.Duration
+	strategy ExecStrategy
 }
 
 // NewExecutableBinWrapper creates ExecutableBinWrapper instance
@@ -52,6 +51,12 @@ func (b *EmbedBinWrapper) Timeout(timeout time.Duration) *EmbedBinWrapper {
 	return b
 }
 
+// Strategy sets how the binary is made executable. By default DefaultStrategy is used.
+func (b *EmbedBinWrapper) Strategy(strategy ExecStrategy) *EmbedBinWrapper {
+	b.strategy = strategy
+	return b
+}
+
 // Arg adds command line argument to run the binary with.
 func (b *EmbedBinWrapper) Arg(name string, values ...string) *EmbedBinWrapper {
 	values = append([]string{name}, values...)
@@ -105,6 +110,19 @@ func (b *EmbedBinWrapper) StdErr() []byte {
 	return b.stdErr
 }
 
+// Clone returns a copy of b with its sources, arguments and settings, but without the state of a run,
+// so that the copy can run concurrently with b.
+func (b *EmbedBinWrapper) Clone() *EmbedBinWrapper {
+	return &EmbedBinWrapper{
+		allSrc:   append([]*Src(nil), b.allSrc...),
+		args:     append([]string(nil), b.args...),
+		env:      append([]string(nil), b.env...),
+		debug:    b.debug,
+		timeout:  b.timeout,
+		strategy: b.strategy,
+	}
+}
+
 // Reset removes all arguments set with Arg method, cleans StdOut and StdErr
 func (b *EmbedBinWrapper) Reset() *EmbedBinWrapper {
 	b.args = []string{}
@@ -172,6 +190,12 @@ func (b *EmbedBinWrapper) findMatchingBinarySrc() (*Src, error) {
 // Arg list is appended to args set through Arg method
 // Returns context.DeadlineExceeded in case of timeout
 func (b *EmbedBinWrapper) Run(arg ...string) error {
+	return b.RunContext(context.Background(), arg...)
+}
+
+// RunContext is like Run but the binary is killed when ctx is done.
+// Returns ctx.Err() if ctx is done before the binary exits.
+func (b *EmbedBinWrapper) RunContext(parent context.Context, arg ...string) error {
 	if len(b.allSrc) == 0 {
 		return fmt.Errorf("need at least one binary source to run")
 	}
@@ -181,13 +205,6 @@ func (b *EmbedBinWrapper) Run(arg ...string) error {
 		return err
 	}
 
-	binExecutable, err := memexec.New(matchedSrc.bin)
-	if err != nil {
-		return err
-	}
-
-	defer binExecutable.Close()
-
 	arg = append(b.args, arg...)
 
 	// if b.debug {
@@ -197,13 +214,63 @@ func (b *EmbedBinWrapper) Run(arg ...string) error {
 	var ctx context.Context
 	var cancel context.CancelFunc
 	if b.timeout > 0 {
-		ctx, cancel = context.WithTimeout(context.Background(), b.timeout)
+		ctx, cancel = context.WithTimeout(parent, b.timeout)
 	} else {
-		ctx = context.Background()
-		cancel = func() {}
+		ctx, cancel = context.WithCancel(parent)
 	}
 	defer cancel()
 
+	strategy := b.strategy
+	if strategy == nil {
+		strategy = DefaultStrategy()
+	}
+
+	binExecutable, primary, stdout, stderr, err := b.start(ctx, strategy, matchedSrc.bin, arg)
+
+	// Executing from memory may be blocked by the host (noexec, seccomp).
+	// An AutoStrategy then switches to its fallback, so retry once with it. Concurrent runs may all fail
+	// with the primary strategy, each of them retries whichever demoted it.
+	if auto, ok := strategy.(*AutoStrategy); ok && err != nil && ctx.Err() == nil && primary {
+		auto.demote(err)
+		binExecutable, _, stdout, stderr, err = b.start(ctx, strategy, matchedSrc.bin, arg)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer binExecutable.Close()
+
+	if stdout != nil {
+		b.stdOut, _ = io.ReadAll(stdout)
+	}
+
+	b.stdErr, _ = io.ReadAll(stderr)
+	err = b.cmd.Wait()
+
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// start prepares bin with strategy and starts it. It reports whether bin was prepared by the primary strategy of an AutoStrategy.
+func (b *EmbedBinWrapper) start(ctx context.Context, strategy ExecStrategy, bin []byte, arg []string) (Executable, bool, io.Reader, io.Reader, error) {
+	var binExecutable Executable
+	var primary bool
+	var err error
+
+	if auto, ok := strategy.(*AutoStrategy); ok {
+		binExecutable, primary, err = auto.prepare(bin)
+	} else {
+		binExecutable, err = strategy.Prepare(bin)
+	}
+
+	if err != nil {
+		return nil, false, nil, nil, err
+	}
+
 	b.cmd = binExecutable.CommandContext(ctx, arg...)
 
 	if b.env != nil {
@@ -227,21 +294,11 @@ func (b *EmbedBinWrapper) Run(arg ...string) error {
 	err = b.cmd.Start()
 
 	if err != nil {
-		return err
-	}
-
-	if stdout != nil {
-		b.stdOut, _ = io.ReadAll(stdout)
+		binExecutable.Close()
+		return nil, primary, nil, nil, err
 	}
 
-	b.stdErr, _ = io.ReadAll(stderr)
-	err = b.cmd.Wait()
-
-	if ctx.Err() == context.DeadlineExceeded {
-		return context.DeadlineExceeded
-	}
-
-	return err
+	return binExecutable, primary, stdout, stderr, nil
 }
 
 // Kill terminates the process
@@ -0,0 +1,302 @@
+package embedbinwrapper
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/amenzhinsky/go-memexec"
+)
+
+// Executable is a binary that has been made runnable by an ExecStrategy.
+type Executable interface {
+	// CommandContext builds a command running the executable with args.
+	CommandContext(ctx context.Context, args ...string) *exec.Cmd
+	// Close releases resources held by the executable.
+	// Commands must not be started after Close was called.
+	Close() error
+}
+
+// ExecStrategy defines how an embedded binary is turned into something the OS can execute.
+type ExecStrategy interface {
+	// Prepare makes bin runnable.
+	Prepare(bin []byte) (Executable, error)
+	// Name returns a short human readable name of the strategy.
+	Name() string
+}
+
+// MemfdStrategy executes binaries from memory using memfd_create on Linux.
+// On other platforms memexec falls back to a temporary file.
+type MemfdStrategy struct{}
+
+// NewMemfdStrategy creates MemfdStrategy instance
+func NewMemfdStrategy() *MemfdStrategy {
+	return &MemfdStrategy{}
+}
+
+// Prepare writes bin into an anonymous memory file.
+func (s *MemfdStrategy) Prepare(bin []byte) (Executable, error) {
+	return memexec.New(bin)
+}
+
+// Name returns "memfd".
+func (s *MemfdStrategy) Name() string {
+	return "memfd"
+}
+
+// DiskStrategy extracts binaries into a cache directory and executes them from there.
+//
+// The directory is created with 0700 permissions. Binaries are named after the SHA-256
+// of their content and written atomically, so concurrent processes can share the same directory.
+type DiskStrategy struct {
+	dir string
+
+	mu       sync.Mutex
+	verified map[string]bool
+}
+
+// NewDiskStrategy creates DiskStrategy extracting binaries to dir.
+// If dir is empty DefaultCacheDir is used.
+func NewDiskStrategy(dir string) *DiskStrategy {
+	return &DiskStrategy{
+		dir:      dir,
+		verified: map[string]bool{},
+	}
+}
+
+// DefaultCacheDir returns the directory DiskStrategy uses when none was specified.
+// It is a "go-mozjpegbin" directory inside os.UserCacheDir, or inside os.TempDir if the former is unavailable.
+func DefaultCacheDir() string {
+	base, err := os.UserCacheDir()
+	if err != nil || base == "" {
+		base = os.TempDir()
+	}
+
+	return filepath.Join(base, "go-mozjpegbin")
+}
+
+// Dir returns the cache directory.
+func (s *DiskStrategy) Dir() string {
+	if s.dir == "" {
+		return DefaultCacheDir()
+	}
+
+	return s.dir
+}
+
+// Prepare extracts bin to the cache directory unless an identical copy is already there.
+func (s *DiskStrategy) Prepare(bin []byte) (Executable, error) {
+	dir := s.Dir()
+
+	if err := ensurePrivateDir(dir); err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(bin)
+	name := hex.EncodeToString(sum[:])
+	path := filepath.Join(dir, name)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.verified[path] {
+		return &diskExecutable{path: path}, nil
+	}
+
+	if !fileHasHash(path, name) {
+		if err := writeFileAtomic(dir, path, bin); err != nil {
+			return nil, err
+		}
+	}
+
+	s.verified[path] = true
+	return &diskExecutable{path: path}, nil
+}
+
+// Name returns "disk".
+func (s *DiskStrategy) Name() string {
+	return "disk"
+}
+
+type diskExecutable struct {
+	path string
+}
+
+func (e *diskExecutable) CommandContext(ctx context.Context, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, e.path, args...)
+}
+
+// Close keeps the extracted binary in the cache so that following runs can reuse it.
+func (e *diskExecutable) Close() error {
+	return nil
+}
+
+func ensurePrivateDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create cache dir: %v", err)
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to stat cache dir: %v", err)
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("cache dir %s is not a directory", dir)
+	}
+
+	if info.Mode().Perm() != 0700 {
+		if err := os.Chmod(dir, 0700); err != nil {
+			return fmt.Errorf("failed to restrict cache dir permissions: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func fileHasHash(path string, hash string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]) == hash
+}
+
+func writeFileAtomic(dir string, path string, data []byte) error {
+	f, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write binary: %v", err)
+	}
+
+	if err := f.Chmod(0700); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to make binary executable: %v", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write binary: %v", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to move binary into cache: %v", err)
+	}
+
+	return nil
+}
+
+// AutoStrategy uses a primary strategy until it fails once, then switches to a fallback for good.
+//
+// A failure is either an error from the primary Prepare or an error starting a command
+// prepared by it, e.g. when seccomp denies memfd_create or execveat.
+type AutoStrategy struct {
+	primary  ExecStrategy
+	fallback ExecStrategy
+	failed   atomic.Bool
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+// NewAutoStrategy creates AutoStrategy instance
+func NewAutoStrategy(primary, fallback ExecStrategy) *AutoStrategy {
+	return &AutoStrategy{
+		primary:  primary,
+		fallback: fallback,
+	}
+}
+
+// Prepare prepares bin with the current strategy.
+func (s *AutoStrategy) Prepare(bin []byte) (Executable, error) {
+	exe, _, err := s.prepare(bin)
+	return exe, err
+}
+
+// prepare prepares bin with the current strategy and reports whether the primary strategy prepared it.
+func (s *AutoStrategy) prepare(bin []byte) (Executable, bool, error) {
+	if !s.failed.Load() {
+		exe, err := s.primary.Prepare(bin)
+		if err == nil {
+			return exe, true, nil
+		}
+
+		s.demote(err)
+	}
+
+	exe, err := s.fallback.Prepare(bin)
+	return exe, false, err
+}
+
+// Name returns the name of the currently selected strategy.
+func (s *AutoStrategy) Name() string {
+	return s.Current().Name()
+}
+
+// Current returns the currently selected strategy.
+func (s *AutoStrategy) Current() ExecStrategy {
+	if s.failed.Load() {
+		return s.fallback
+	}
+
+	return s.primary
+}
+
+// Err returns the error that made AutoStrategy switch to the fallback, if any.
+func (s *AutoStrategy) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+// demote switches to the fallback strategy. It returns false if it was already selected.
+func (s *AutoStrategy) demote(err error) bool {
+	if s.failed.Swap(true) {
+		return false
+	}
+
+	s.mu.Lock()
+	s.lastErr = fmt.Errorf("%s strategy failed: %w", s.primary.Name(), err)
+	s.mu.Unlock()
+	return true
+}
+
+var (
+	defaultStrategyMu sync.RWMutex
+	defaultStrategy   ExecStrategy = NewAutoStrategy(NewMemfdStrategy(), NewDiskStrategy(""))
+)
+
+// DefaultStrategy returns the strategy used by wrappers that don't have one set explicitly.
+// Unless changed with SetDefaultStrategy it's an AutoStrategy preferring memfd over DefaultCacheDir.
+func DefaultStrategy() ExecStrategy {
+	defaultStrategyMu.RLock()
+	defer defaultStrategyMu.RUnlock()
+	return defaultStrategy
+}
+
+// SetDefaultStrategy sets the strategy used by wrappers that don't have one set explicitly.
+func SetDefaultStrategy(s ExecStrategy) error {
+	if s == nil {
+		return errors.New("strategy must not be nil")
+	}
+
+	defaultStrategyMu.Lock()
+	defer defaultStrategyMu.Unlock()
+	defaultStrategy = s
+	return nil
+}
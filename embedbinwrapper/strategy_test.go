@@ -0,0 +1,153 @@
+package embedbinwrapper_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin/embedbinwrapper"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingStrategy struct{}
+
+func (failingStrategy) Prepare(bin []byte) (embedbinwrapper.Executable, error) {
+	return nil, errors.New("memfd_create: operation not permitted")
+}
+
+func (failingStrategy) Name() string {
+	return "failing"
+}
+
+// unstartableStrategy prepares executables that fail to start, like memfd binaries when execveat is denied.
+// Prepare returns once n executables have been prepared, so that they all fail together.
+type unstartableStrategy struct {
+	n        int
+	mu       sync.Mutex
+	prepared int
+	ready    chan struct{}
+}
+
+func (s *unstartableStrategy) Prepare(bin []byte) (embedbinwrapper.Executable, error) {
+	s.mu.Lock()
+	s.prepared++
+	if s.prepared == s.n {
+		close(s.ready)
+	}
+	s.mu.Unlock()
+
+	<-s.ready
+	return unstartable{}, nil
+}
+
+func (s *unstartableStrategy) Name() string {
+	return "unstartable"
+}
+
+type unstartable struct{}
+
+func (unstartable) CommandContext(ctx context.Context, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, "/nonexistent/binary", args...)
+}
+
+func (unstartable) Close() error {
+	return nil
+}
+
+func readTrue(t *testing.T) []byte {
+	bin, err := os.ReadFile("/bin/true")
+	if err != nil {
+		t.Skip("/bin/true is not available")
+	}
+
+	return bin
+}
+
+func TestDiskStrategy(t *testing.T) {
+	bin := readTrue(t)
+	dir := filepath.Join(t.TempDir(), "cache")
+
+	s := embedbinwrapper.NewDiskStrategy(dir)
+	exe, err := s.Prepare(bin)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer exe.Close()
+
+	info, err := os.Stat(dir)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+
+	sum := sha256.Sum256(bin)
+	_, err = os.Stat(filepath.Join(dir, hex.EncodeToString(sum[:])))
+	assert.Nil(t, err)
+
+	assert.Nil(t, exe.CommandContext(context.Background()).Run())
+}
+
+func TestDiskStrategyReplacesCorruptedBinary(t *testing.T) {
+	bin := readTrue(t)
+	dir := t.TempDir()
+	sum := sha256.Sum256(bin)
+	path := filepath.Join(dir, hex.EncodeToString(sum[:]))
+
+	if !assert.Nil(t, os.WriteFile(path, []byte("garbage"), 0700)) {
+		t.FailNow()
+	}
+
+	_, err := embedbinwrapper.NewDiskStrategy(dir).Prepare(bin)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, bin, data)
+}
+
+func TestAutoStrategyFallback(t *testing.T) {
+	bin := readTrue(t)
+	s := embedbinwrapper.NewAutoStrategy(failingStrategy{}, embedbinwrapper.NewDiskStrategy(t.TempDir()))
+
+	b := embedbinwrapper.NewExecutableBinWrapper().
+		Src(embedbinwrapper.NewSrc().Bin(bin)).
+		Strategy(s)
+
+	assert.Nil(t, b.Run())
+	assert.Equal(t, "disk", s.Name())
+	assert.NotNil(t, s.Err())
+}
+
+func TestAutoStrategyConcurrentFallback(t *testing.T) {
+	bin := readTrue(t)
+	const n = 4
+	s := embedbinwrapper.NewAutoStrategy(&unstartableStrategy{n: n, ready: make(chan struct{})}, embedbinwrapper.NewDiskStrategy(t.TempDir()))
+
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = embedbinwrapper.NewExecutableBinWrapper().
+				Src(embedbinwrapper.NewSrc().Bin(bin)).
+				Strategy(s).
+				Run()
+		}()
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, "disk", s.Name())
+}
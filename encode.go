@@ -3,6 +3,7 @@ package mozjpegbin
 import (
 	"fmt"
 	"image"
+	"image/color"
 	"io"
 )
 
@@ -10,19 +11,48 @@ import (
 type Options struct {
 	Quality  uint
 	Optimize bool
+	// MaxBytes, when positive, makes Encode use the highest quality whose output is at most MaxBytes bytes long.
+	// Quality is then the highest quality tried, or 100 if it's 0. See CJpeg.TargetSize.
+	MaxBytes int
+	// ICCProfile, if set, is embedded into the output. See CJpeg.ICCProfile.
+	ICCProfile []byte
+	// Background is the color transparent images are composited over, white if nil. See CJpeg.Background.
+	Background color.Color
+	// Resize, if set, scales the image before encoding. See CJpeg.Resize.
+	Resize *Resize
 }
 
-// Encode encodes image.Image into jpeg using cjpeg.
+// Encode encodes image.Image into jpeg using cjpeg with the default runner.
 func Encode(w io.Writer, m image.Image, o *Options) error {
+	_, err := EncodeWithResult(w, m, o)
+	return err
+}
+
+// EncodeWithResult works like Encode and also reports the quality and the size of the output.
+func EncodeWithResult(w io.Writer, m image.Image, o *Options) (*EncodeResult, error) {
 	cjpeg, err := NewCJpeg()
 	if err != nil {
-		return fmt.Errorf("NewCJpeg failed: %v", err)
+		return nil, fmt.Errorf("NewCJpeg failed: %v", err)
 	}
 
 	if o != nil {
-		cjpeg.Quality(o.Quality)
+		if o.MaxBytes <= 0 || o.Quality > 0 {
+			cjpeg.Quality(o.Quality)
+		}
+
 		cjpeg.Optimize(o.Optimize)
+		cjpeg.TargetSize(o.MaxBytes)
+		cjpeg.ICCProfile(o.ICCProfile)
+		cjpeg.Background(o.Background)
+
+		if o.Resize != nil {
+			cjpeg.Resize(o.Resize.Width, o.Resize.Height, o.Resize.Filter).ResizeMode(o.Resize.Mode)
+		}
+	}
+
+	if err := cjpeg.InputImage(m).Output(w).Run(); err != nil {
+		return nil, err
 	}
 
-	return cjpeg.InputImage(m).Output(w).Run()
+	return cjpeg.Result(), nil
 }
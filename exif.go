@@ -0,0 +1,333 @@
+package mozjpegbin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// EXIF tags with a meaning for MetadataPolicy.
+const (
+	ExifTagOrientation = 0x0112
+	ExifTagArtist      = 0x013B
+	ExifTagCopyright   = 0x8298
+	ExifTagMakerNote   = 0x927C
+
+	exifTagExifIFD      = 0x8769
+	exifTagGPSIFD       = 0x8825
+	exifTagInteropIFD   = 0xA005
+	exifTagThumbnail    = 0x0201
+	exifTagThumbnailLen = 0x0202
+)
+
+const exifPrefix = "Exif\x00\x00"
+
+// tiffEntry is an IFD entry. value holds the raw bytes of the value in the byte order of the file.
+type tiffEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+}
+
+// byteOrder reads and appends integers in the byte order of an EXIF block.
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// exifData is an EXIF block split into its IFDs. Pointers to sub-IFDs and to the thumbnail
+// are not kept as entries, they are recreated by encode.
+type exifData struct {
+	order                          byteOrder
+	ifd0, exif, gps, interop, ifd1 []tiffEntry
+	thumbnail                      []byte
+}
+
+var tiffTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4}
+
+// parseExif parses the payload of an EXIF APP1 segment, including the "Exif\0\0" prefix.
+func parseExif(payload []byte) (*exifData, error) {
+	if !bytes.HasPrefix(payload, []byte(exifPrefix)) {
+		return nil, errors.New("not an exif segment")
+	}
+
+	tiff := payload[len(exifPrefix):]
+	if len(tiff) < 8 {
+		return nil, errors.New("invalid exif: header too short")
+	}
+
+	e := &exifData{}
+	switch string(tiff[:2]) {
+	case "II":
+		e.order = binary.LittleEndian
+	case "MM":
+		e.order = binary.BigEndian
+	default:
+		return nil, errors.New("invalid exif: unknown byte order")
+	}
+
+	if e.order.Uint16(tiff[2:]) != 42 {
+		return nil, errors.New("invalid exif: bad magic number")
+	}
+
+	ifd0, next, err := e.readIFD(tiff, e.order.Uint32(tiff[4:]))
+	if err != nil {
+		return nil, err
+	}
+
+	var pointers []tiffEntry
+	e.ifd0, pointers = splitPointers(ifd0, exifTagExifIFD, exifTagGPSIFD)
+
+	for _, p := range pointers {
+		entries, _, err := e.readIFD(tiff, e.order.Uint32(p.value))
+		if err != nil {
+			return nil, err
+		}
+
+		if p.tag == exifTagGPSIFD {
+			e.gps = entries
+			continue
+		}
+
+		var interop []tiffEntry
+		e.exif, interop = splitPointers(entries, exifTagInteropIFD)
+		if len(interop) > 0 {
+			if e.interop, _, err = e.readIFD(tiff, e.order.Uint32(interop[0].value)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if next != 0 {
+		ifd1, _, err := e.readIFD(tiff, next)
+		if err != nil {
+			return nil, err
+		}
+
+		var thumb []tiffEntry
+		e.ifd1, thumb = splitPointers(ifd1, exifTagThumbnail, exifTagThumbnailLen)
+
+		var offset, length uint32
+		for _, t := range thumb {
+			if t.tag == exifTagThumbnail {
+				offset = e.order.Uint32(t.value)
+			} else if t.typ == 3 {
+				length = uint32(e.order.Uint16(t.value))
+			} else {
+				length = e.order.Uint32(t.value)
+			}
+		}
+
+		if length > 0 && uint64(offset)+uint64(length) <= uint64(len(tiff)) {
+			e.thumbnail = tiff[offset : offset+length]
+		}
+	}
+
+	return e, nil
+}
+
+// readIFD reads the IFD at offset and returns its entries and the offset of the next IFD.
+func (e *exifData) readIFD(tiff []byte, offset uint32) ([]tiffEntry, uint32, error) {
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return nil, 0, fmt.Errorf("invalid exif: IFD offset %d out of range", offset)
+	}
+
+	n := int(e.order.Uint16(tiff[offset:]))
+	pos := int(offset) + 2
+	if pos+n*12+4 > len(tiff) {
+		return nil, 0, errors.New("invalid exif: IFD out of range")
+	}
+
+	entries := make([]tiffEntry, 0, n)
+	for i := 0; i < n; i, pos = i+1, pos+12 {
+		entry := tiffEntry{
+			tag:   e.order.Uint16(tiff[pos:]),
+			typ:   e.order.Uint16(tiff[pos+2:]),
+			count: e.order.Uint32(tiff[pos+4:]),
+		}
+
+		size, ok := tiffTypeSizes[entry.typ]
+		if !ok {
+			// Unknown types can't be relocated.
+			continue
+		}
+
+		length := uint64(size) * uint64(entry.count)
+		if length <= 4 {
+			entry.value = tiff[pos+8 : pos+8+int(length)]
+		} else {
+			valueOffset := uint64(e.order.Uint32(tiff[pos+8:]))
+			if valueOffset+length > uint64(len(tiff)) {
+				return nil, 0, fmt.Errorf("invalid exif: value of tag 0x%04X out of range", entry.tag)
+			}
+
+			entry.value = tiff[valueOffset : valueOffset+length]
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, e.order.Uint32(tiff[pos:]), nil
+}
+
+// splitPointers separates entries with tags from the others.
+func splitPointers(entries []tiffEntry, tags ...uint16) ([]tiffEntry, []tiffEntry) {
+	var rest, found []tiffEntry
+
+	for _, entry := range entries {
+		isPointer := false
+		for _, tag := range tags {
+			isPointer = isPointer || entry.tag == tag
+		}
+
+		if isPointer && len(entry.value) >= 2 {
+			found = append(found, entry)
+		} else if !isPointer {
+			rest = append(rest, entry)
+		}
+	}
+
+	return rest, found
+}
+
+// empty reports whether e holds nothing worth writing.
+func (e *exifData) empty() bool {
+	return len(e.ifd0)+len(e.exif)+len(e.gps)+len(e.interop) == 0 && e.thumbnail == nil
+}
+
+// encode serializes e into the payload of an EXIF APP1 segment, including the "Exif\0\0" prefix.
+func (e *exifData) encode() []byte {
+	ifd0 := append([]tiffEntry(nil), e.ifd0...)
+	exif := append([]tiffEntry(nil), e.exif...)
+	ifd1 := append([]tiffEntry(nil), e.ifd1...)
+
+	// Pointers are placeholders until the layout is known.
+	pointer := func(tag uint16) tiffEntry {
+		return tiffEntry{tag: tag, typ: 4, count: 1, value: make([]byte, 4)}
+	}
+
+	if len(e.interop) > 0 {
+		exif = append(exif, pointer(exifTagInteropIFD))
+	}
+
+	if len(exif) > 0 {
+		ifd0 = append(ifd0, pointer(exifTagExifIFD))
+	}
+
+	if len(e.gps) > 0 {
+		ifd0 = append(ifd0, pointer(exifTagGPSIFD))
+	}
+
+	hasIFD1 := len(ifd1) > 0 || e.thumbnail != nil
+	if e.thumbnail != nil {
+		ifd1 = append(ifd1, pointer(exifTagThumbnail), pointer(exifTagThumbnailLen))
+	}
+
+	// Layout: header, IFD0, Exif IFD, Interop IFD, GPS IFD, IFD1, thumbnail.
+	ifds := [][]tiffEntry{ifd0, exif, e.interop, e.gps, ifd1}
+	offsets := make([]uint32, len(ifds))
+	pos := uint32(8)
+
+	for i, entries := range ifds {
+		if i > 0 && len(entries) == 0 {
+			continue
+		}
+
+		offsets[i] = pos
+		pos += ifdSize(entries)
+	}
+
+	thumbOffset := pos
+
+	for i := range ifds {
+		for j := range ifds[i] {
+			entry := &ifds[i][j]
+			switch entry.tag {
+			case exifTagExifIFD:
+				e.order.PutUint32(entry.value, offsets[1])
+			case exifTagInteropIFD:
+				e.order.PutUint32(entry.value, offsets[2])
+			case exifTagGPSIFD:
+				e.order.PutUint32(entry.value, offsets[3])
+			case exifTagThumbnail:
+				if i == 4 {
+					e.order.PutUint32(entry.value, thumbOffset)
+				}
+			case exifTagThumbnailLen:
+				if i == 4 {
+					e.order.PutUint32(entry.value, uint32(len(e.thumbnail)))
+				}
+			}
+		}
+	}
+
+	out := bytes.NewBufferString(exifPrefix)
+	if e.order == binary.LittleEndian {
+		out.WriteString("II")
+	} else {
+		out.WriteString("MM")
+	}
+
+	out.Write(e.order.AppendUint16(nil, 42))
+	out.Write(e.order.AppendUint32(nil, 8))
+
+	for i, entries := range ifds {
+		if offsets[i] == 0 {
+			continue
+		}
+
+		var next uint32
+		if i == 0 && hasIFD1 {
+			next = offsets[4]
+		}
+
+		out.Write(e.encodeIFD(entries, offsets[i], next))
+	}
+
+	out.Write(e.thumbnail)
+	return out.Bytes()
+}
+
+func ifdSize(entries []tiffEntry) uint32 {
+	size := uint32(2 + 12*len(entries) + 4)
+	for _, entry := range entries {
+		if len(entry.value) > 4 {
+			size += uint32(len(entry.value)+1) &^ 1
+		}
+	}
+
+	return size
+}
+
+// encodeIFD serializes entries at offset, with their values following the entries.
+func (e *exifData) encodeIFD(entries []tiffEntry, offset, next uint32) []byte {
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	out := e.order.AppendUint16(nil, uint16(len(entries)))
+	valueOffset := offset + uint32(2+12*len(entries)+4)
+	var values []byte
+
+	for _, entry := range entries {
+		out = e.order.AppendUint16(out, entry.tag)
+		out = e.order.AppendUint16(out, entry.typ)
+		out = e.order.AppendUint32(out, entry.count)
+
+		if len(entry.value) <= 4 {
+			var inline [4]byte
+			copy(inline[:], entry.value)
+			out = append(out, inline[:]...)
+			continue
+		}
+
+		out = e.order.AppendUint32(out, valueOffset+uint32(len(values)))
+		values = append(values, entry.value...)
+		if len(values)%2 == 1 {
+			values = append(values, 0)
+		}
+	}
+
+	out = e.order.AppendUint32(out, next)
+	return append(out, values...)
+}
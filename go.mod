@@ -5,6 +5,8 @@ go 1.22.2
 require (
 	github.com/amenzhinsky/go-memexec v0.7.1
 	github.com/stretchr/testify v1.9.0
+	github.com/tetratelabs/wazero v1.7.3
+	golang.org/x/image v0.18.0
 )
 
 require (
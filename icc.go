@@ -0,0 +1,159 @@
+package mozjpegbin
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+const (
+	iccPrefix = "ICC_PROFILE\x00"
+	// iccChunkSize is the largest part of a profile fitting into an APP2 segment,
+	// after the prefix, the sequence number and the chunk count.
+	iccChunkSize = 65535 - 2 - len(iccPrefix) - 2
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// extractICC returns the ICC profile embedded into a JPEG or PNG file, or nil if there is none.
+func extractICC(data []byte) ([]byte, error) {
+	if isJPEG(data) {
+		segments, _, err := splitHeader(data)
+		if err != nil {
+			return nil, err
+		}
+
+		return jpegICC(segments)
+	}
+
+	if bytes.HasPrefix(data, pngSignature) {
+		return pngICC(data)
+	}
+
+	return nil, nil
+}
+
+// jpegICC reassembles the ICC profile from APP2 segments.
+func jpegICC(segments []segment) ([]byte, error) {
+	type chunk struct {
+		seq  int
+		data []byte
+	}
+
+	var chunks []chunk
+	for _, s := range segments {
+		if s.marker != markerAPP2 || !s.hasPrefix(iccPrefix) {
+			continue
+		}
+
+		if len(s.data) < len(iccPrefix)+2 {
+			return nil, errors.New("invalid ICC_PROFILE segment")
+		}
+
+		chunks = append(chunks, chunk{seq: int(s.data[len(iccPrefix)]), data: s.data[len(iccPrefix)+2:]})
+	}
+
+	if len(chunks) == 0 {
+		return nil, nil
+	}
+
+	sort.SliceStable(chunks, func(i, j int) bool { return chunks[i].seq < chunks[j].seq })
+
+	var profile []byte
+	for i, c := range chunks {
+		if c.seq != i+1 {
+			return nil, fmt.Errorf("invalid ICC_PROFILE segments: chunk %d missing", i+1)
+		}
+
+		profile = append(profile, c.data...)
+	}
+
+	return profile, nil
+}
+
+// pngICC returns the profile of the iCCP chunk of a PNG file.
+func pngICC(data []byte) ([]byte, error) {
+	pos := len(pngSignature)
+
+	for pos+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		kind := string(data[pos+4 : pos+8])
+		if length < 0 || pos+12+length > len(data) {
+			return nil, errors.New("invalid png chunk")
+		}
+
+		chunk := data[pos+8 : pos+8+length]
+
+		switch kind {
+		case "iCCP":
+			// Profile name, NUL, compression method and zlib stream.
+			nul := bytes.IndexByte(chunk, 0)
+			if nul < 0 || nul+2 > len(chunk) || chunk[nul+1] != 0 {
+				return nil, errors.New("invalid png iCCP chunk")
+			}
+
+			r, err := zlib.NewReader(bytes.NewReader(chunk[nul+2:]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid png iCCP chunk: %v", err)
+			}
+
+			defer r.Close()
+			return io.ReadAll(r)
+		case "IDAT", "IEND":
+			return nil, nil
+		}
+
+		pos += 12 + length
+	}
+
+	return nil, nil
+}
+
+// setICC replaces the ICC profile of a JPEG file with profile, split into APP2 segments.
+// The segments are placed after the leading APP0 and APP1 segments.
+func setICC(data, profile []byte) ([]byte, error) {
+	segments, rest, err := splitHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	count := (len(profile) + iccChunkSize - 1) / iccChunkSize
+	if count > 255 {
+		return nil, errors.New("ICC profile is too large")
+	}
+
+	var icc []segment
+	for i := 0; i < count; i++ {
+		part := profile[i*iccChunkSize : min((i+1)*iccChunkSize, len(profile))]
+		payload := make([]byte, 0, len(iccPrefix)+2+len(part))
+		payload = append(payload, iccPrefix...)
+		payload = append(payload, byte(i+1), byte(count))
+		icc = append(icc, segment{marker: markerAPP2, data: append(payload, part...)})
+	}
+
+	var result []segment
+	inserted := false
+
+	for _, s := range segments {
+		if s.marker == markerAPP2 && s.hasPrefix(iccPrefix) {
+			continue
+		}
+
+		if !inserted && s.marker != markerAPP0 && s.marker != markerAPP1 {
+			result = append(result, icc...)
+			inserted = true
+		}
+
+		result = append(result, s)
+	}
+
+	if !inserted {
+		result = append(result, icc...)
+	}
+
+	return joinHeader(result, rest), nil
+}
@@ -0,0 +1,157 @@
+package mozjpegbin_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/stretchr/testify/assert"
+)
+
+// testProfile returns fake profile data large enough to need several APP2 segments.
+func testProfile() []byte {
+	profile := make([]byte, 150000)
+	for i := range profile {
+		profile[i] = byte(i * 7)
+	}
+	return profile
+}
+
+// readICC reassembles the ICC profile of a JPEG file.
+func readICC(t *testing.T, data []byte) []byte {
+	info, err := mozjpegbin.Inspect(bytes.NewReader(data))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var profile []byte
+	for i, m := range info.Markers {
+		if m.Identifier != "ICC_PROFILE" {
+			continue
+		}
+
+		payload := data[m.Offset+4 : m.Offset+4+m.Size]
+		assert.Equal(t, byte(len(profile)/65519+1), payload[12], "chunk %d", i)
+		profile = append(profile, payload[14:]...)
+	}
+
+	return profile
+}
+
+func jpegWithICC(t *testing.T, profile []byte) []byte {
+	source := encodeStdJpeg(t, 80)
+	data := []byte{0xFF, 0xD8}
+
+	count := (len(profile) + 65518) / 65519
+	for i := 0; i < count; i++ {
+		chunk := profile[i*65519 : min((i+1)*65519, len(profile))]
+		data = append(data, testSegment(0xE2, append([]byte{'I', 'C', 'C', '_', 'P', 'R', 'O', 'F', 'I', 'L', 'E', 0, byte(i + 1), byte(count)}, chunk...))...)
+	}
+
+	return append(data, source[2:]...)
+}
+
+func pngWithICC(t *testing.T, profile []byte) []byte {
+	var buf bytes.Buffer
+	if !assert.Nil(t, png.Encode(&buf, testImage())) {
+		t.FailNow()
+	}
+
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write(profile)
+	w.Close()
+
+	chunk := append([]byte("iCCPDisplay P3\x00\x00"), compressed.Bytes()...)
+	iccp := binary.BigEndian.AppendUint32(nil, uint32(len(chunk)-4))
+	iccp = append(iccp, chunk...)
+	iccp = binary.BigEndian.AppendUint32(iccp, crc32.ChecksumIEEE(chunk))
+
+	// The signature and IHDR take the first 33 bytes.
+	data := buf.Bytes()
+	return append(append(append([]byte(nil), data[:33]...), iccp...), data[33:]...)
+}
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i)
+	}
+	return img
+}
+
+func TestCJpegKeepsJpegICC(t *testing.T) {
+	c, err := mozjpegbin.NewCJpeg()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	profile := testProfile()
+	var out bytes.Buffer
+	err = c.Input(bytes.NewReader(jpegWithICC(t, profile))).Output(&out).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, profile, readICC(t, out.Bytes()))
+	assert.Equal(t, out.Len(), c.Result().Size)
+
+	_, err = mozjpegbin.Decode(&out)
+	assert.Nil(t, err)
+
+	out.Reset()
+	err = c.Input(bytes.NewReader(jpegWithICC(t, profile))).Output(&out).KeepICC(false).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Nil(t, readICC(t, out.Bytes()))
+}
+
+func TestCJpegKeepsPngICC(t *testing.T) {
+	c, err := mozjpegbin.NewCJpeg()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	profile := testProfile()[:3000]
+	var out bytes.Buffer
+	err = c.Input(bytes.NewReader(pngWithICC(t, profile))).Output(&out).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, profile, readICC(t, out.Bytes()))
+}
+
+func TestEncodeICCProfile(t *testing.T) {
+	profile := testProfile()
+	var out bytes.Buffer
+	err := mozjpegbin.Encode(&out, testImage(), &mozjpegbin.Options{Quality: 80, ICCProfile: profile})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, profile, readICC(t, out.Bytes()))
+}
+
+func TestCJpegSmartRecompressKeepsICC(t *testing.T) {
+	c, err := mozjpegbin.NewCJpeg()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	profile := testProfile()
+	var out bytes.Buffer
+	err = c.Input(bytes.NewReader(jpegWithICC(t, profile))).Output(&out).SmartRecompress(0).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, profile, readICC(t, out.Bytes()))
+}
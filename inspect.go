@@ -0,0 +1,238 @@
+package mozjpegbin
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Info describes the structure of a JPEG file.
+type Info struct {
+	Width, Height int
+	// Precision is the number of bits per sample, 8 for almost all files.
+	Precision  int
+	Components []ComponentInfo
+	// Baseline reports whether the file is baseline sequential (SOF0).
+	Baseline    bool
+	Progressive bool
+	Arithmetic  bool
+	// RestartInterval is the number of MCUs between restart markers in the first scan, 0 if there are none.
+	RestartInterval int
+	// QuantTables are the quantization tables by table id, in natural order.
+	// A table redefined between scans is reported with its last values.
+	QuantTables map[int][64]int
+	// Quality is the IJG quality estimated from QuantTables, 0 if it can't be estimated.
+	Quality int
+	// Scans is the number of scans.
+	Scans int
+	// Markers are the APPn and COM segments, in file order.
+	Markers []MarkerInfo
+}
+
+// ComponentInfo describes a color component.
+type ComponentInfo struct {
+	ID int
+	// H and V are the horizontal and vertical sampling factors.
+	H, V       int
+	QuantTable int
+}
+
+// MarkerInfo describes an APPn or COM segment.
+type MarkerInfo struct {
+	// Marker is the second byte of the marker, e.g. 0xE1 for APP1.
+	Marker byte
+	// Offset is the position of the marker in the file.
+	Offset int
+	// Size is the size of the payload, without the marker and the length field.
+	Size int
+	// Identifier is the NUL terminated string at the start of APPn payloads, like "Exif" or "ICC_PROFILE".
+	Identifier string
+}
+
+// Name returns the name of the marker, like "APP1" or "COM".
+func (m MarkerInfo) Name() string {
+	if m.Marker == markerCOM {
+		return "COM"
+	}
+
+	return fmt.Sprintf("APP%d", m.Marker-markerAPP0)
+}
+
+// Inspect reads the structure of a JPEG file without decoding pixels.
+func Inspect(r io.Reader) (*Info, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return inspect(data)
+}
+
+func inspect(data []byte) (*Info, error) {
+	if !isJPEG(data) {
+		return nil, errors.New("not a jpeg file: missing SOI marker")
+	}
+
+	info := &Info{}
+	var dqt []segment
+	restartInterval := 0
+	frame := false
+	pos := 2
+
+	for {
+		for pos+1 < len(data) && data[pos] == 0xFF && data[pos+1] == 0xFF {
+			pos++
+		}
+
+		if pos+2 > len(data) {
+			return nil, errors.New("invalid jpeg: unexpected end of file")
+		}
+
+		if data[pos] != 0xFF {
+			return nil, fmt.Errorf("invalid jpeg marker at offset %d", pos)
+		}
+
+		marker := data[pos+1]
+		if marker == markerEOI {
+			break
+		}
+
+		if marker == 0x01 || marker >= markerRST0 && marker <= markerRST0+7 {
+			pos += 2
+			continue
+		}
+
+		if pos+4 > len(data) {
+			return nil, errors.New("invalid jpeg: unexpected end of file")
+		}
+
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil, fmt.Errorf("invalid length of jpeg marker 0x%X at offset %d", marker, pos)
+		}
+
+		payload := data[pos+4 : pos+2+length]
+		s := segment{marker: marker, data: payload}
+
+		switch {
+		case s.isAPP() || marker == markerCOM:
+			m := MarkerInfo{Marker: marker, Offset: pos, Size: len(payload)}
+			if s.isAPP() {
+				m.Identifier = appIdentifier(payload)
+			}
+			info.Markers = append(info.Markers, m)
+		case marker == markerDQT:
+			dqt = append(dqt, s)
+		case marker == markerDRI:
+			if len(payload) < 2 {
+				return nil, errors.New("invalid DRI segment")
+			}
+			restartInterval = int(binary.BigEndian.Uint16(payload))
+		case isSOF(marker):
+			if frame {
+				return nil, errors.New("invalid jpeg: more than one frame")
+			}
+
+			frame = true
+			if err := info.readFrame(marker, payload); err != nil {
+				return nil, err
+			}
+		case marker == markerSOS:
+			if !frame {
+				return nil, errors.New("invalid jpeg: scan before frame")
+			}
+
+			if info.Scans == 0 {
+				info.RestartInterval = restartInterval
+			}
+
+			info.Scans++
+			pos = skipEntropyData(data, pos+2+length)
+			continue
+		}
+
+		pos += 2 + length
+	}
+
+	if !frame {
+		return nil, errors.New("invalid jpeg: no frame found")
+	}
+
+	tables, err := quantTables(dqt)
+	if err != nil {
+		return nil, err
+	}
+
+	info.QuantTables = tables
+	if quality, err := estimateQuality(tables); err == nil {
+		info.Quality = quality
+	}
+
+	return info, nil
+}
+
+// markerRST0 is the first of the restart markers RST0 to RST7.
+const markerRST0 = 0xD0
+
+// isSOF reports whether marker starts a frame. 0xC4, 0xC8 and 0xCC are DHT, JPG and DAC.
+func isSOF(marker byte) bool {
+	return marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC
+}
+
+func (info *Info) readFrame(marker byte, payload []byte) error {
+	if len(payload) < 6 || len(payload) < 6+int(payload[5])*3 {
+		return errors.New("invalid SOF segment")
+	}
+
+	info.Precision = int(payload[0])
+	info.Height = int(binary.BigEndian.Uint16(payload[1:]))
+	info.Width = int(binary.BigEndian.Uint16(payload[3:]))
+	info.Baseline = marker == 0xC0
+	info.Progressive = isProgressiveSOF(marker)
+	info.Arithmetic = marker >= 0xC9
+
+	for i := 0; i < int(payload[5]); i++ {
+		c := payload[6+i*3:]
+		info.Components = append(info.Components, ComponentInfo{
+			ID:         int(c[0]),
+			H:          int(c[1] >> 4),
+			V:          int(c[1] & 0x0F),
+			QuantTable: int(c[2]),
+		})
+	}
+
+	return nil
+}
+
+// skipEntropyData returns the position of the first marker after the entropy coded data starting at pos.
+// Stuffed zero bytes and restart markers belong to the data.
+func skipEntropyData(data []byte, pos int) int {
+	for ; pos+1 < len(data); pos++ {
+		if data[pos] != 0xFF {
+			continue
+		}
+
+		next := data[pos+1]
+		if next != 0 && next != 0xFF && (next < markerRST0 || next > markerRST0+7) {
+			return pos
+		}
+	}
+
+	return len(data)
+}
+
+// appIdentifier returns the NUL terminated identifier at the start of an APPn payload.
+func appIdentifier(payload []byte) string {
+	for i, b := range payload {
+		if b == 0 {
+			return string(payload[:i])
+		}
+
+		if b < 0x20 || b > 0x7E || i >= 64 {
+			break
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,79 @@
+package mozjpegbin_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInspectBaseline(t *testing.T) {
+	source := encodeStdJpeg(t, 50)
+
+	// Insert APP1 and COM segments after SOI.
+	app1 := append([]byte{0xFF, 0xE1, 0x00, 0x0A}, "Exif\x00\x00ab"...)
+	com := append([]byte{0xFF, 0xFE, 0x00, 0x07}, "hello"...)
+	data := append(append(append([]byte{0xFF, 0xD8}, app1...), com...), source[2:]...)
+
+	info, err := mozjpegbin.Inspect(bytes.NewReader(data))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, 1203, info.Width)
+	assert.Equal(t, 901, info.Height)
+	assert.Equal(t, 8, info.Precision)
+	assert.True(t, info.Baseline)
+	assert.False(t, info.Progressive)
+	assert.False(t, info.Arithmetic)
+	assert.Equal(t, 1, info.Scans)
+	assert.Equal(t, 50, info.Quality)
+	assert.Len(t, info.QuantTables, 2)
+	assert.Equal(t, []mozjpegbin.ComponentInfo{
+		{ID: 1, H: 2, V: 2, QuantTable: 0},
+		{ID: 2, H: 1, V: 1, QuantTable: 1},
+		{ID: 3, H: 1, V: 1, QuantTable: 1},
+	}, info.Components)
+	assert.Equal(t, []mozjpegbin.MarkerInfo{
+		{Marker: 0xE1, Offset: 2, Size: 8, Identifier: "Exif"},
+		{Marker: 0xFE, Offset: 14, Size: 5},
+	}, info.Markers)
+	assert.Equal(t, "APP1", info.Markers[0].Name())
+	assert.Equal(t, "COM", info.Markers[1].Name())
+}
+
+func TestInspectProgressiveArithmetic(t *testing.T) {
+	c, err := mozjpegbin.NewCJpeg()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	for _, arithmetic := range []bool{false, true} {
+		var out bytes.Buffer
+		err = c.InputFile("source.jpg").Output(&out).Arithmetic(arithmetic).Run()
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+
+		info, err := mozjpegbin.Inspect(&out)
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+
+		assert.True(t, info.Progressive)
+		assert.False(t, info.Baseline)
+		assert.Equal(t, arithmetic, info.Arithmetic)
+		assert.Greater(t, info.Scans, 1)
+		assert.Equal(t, 1203, info.Width)
+	}
+}
+
+func TestInspectInvalid(t *testing.T) {
+	_, err := mozjpegbin.Inspect(bytes.NewReader([]byte("not a jpeg")))
+	assert.NotNil(t, err)
+
+	source := encodeStdJpeg(t, 50)
+	_, err = mozjpegbin.Inspect(bytes.NewReader(source[:100]))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,275 @@
+// Package similarity implements SSIM and MS-SSIM on single channel planes.
+package similarity
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+)
+
+const (
+	// window is the size of the gaussian window, as in the reference implementation.
+	window = 11
+	sigma  = 1.5
+	k1     = 0.01
+	k2     = 0.03
+	peak   = 255.0
+)
+
+var (
+	c1 = (k1 * peak) * (k1 * peak)
+	c2 = (k2 * peak) * (k2 * peak)
+)
+
+// msssimWeights are the per scale exponents from Wang, Simoncelli and Bovik (2003).
+var msssimWeights = []float64{0.0448, 0.2856, 0.3001, 0.2363, 0.1333}
+
+var kernel = gaussianKernel()
+
+// Plane is a single channel of an image with samples in [0, 255].
+type Plane struct {
+	Width, Height int
+	Pix           []float64
+}
+
+// NewPlane returns a plane of the given size filled with zeros.
+func NewPlane(width, height int) *Plane {
+	return &Plane{Width: width, Height: height, Pix: make([]float64, width*height)}
+}
+
+// Luma returns the luma channel of img, computed the way JPEG does.
+func Luma(img image.Image) *Plane {
+	b := img.Bounds()
+	p := NewPlane(b.Dx(), b.Dy())
+
+	switch img := img.(type) {
+	case *image.YCbCr:
+		for y := 0; y < p.Height; y++ {
+			row := img.Y[img.YOffset(b.Min.X, b.Min.Y+y):]
+			for x := 0; x < p.Width; x++ {
+				p.Pix[y*p.Width+x] = float64(row[x])
+			}
+		}
+	case *image.Gray:
+		for y := 0; y < p.Height; y++ {
+			row := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):]
+			for x := 0; x < p.Width; x++ {
+				p.Pix[y*p.Width+x] = float64(row[x])
+			}
+		}
+	case *image.RGBA:
+		for y := 0; y < p.Height; y++ {
+			row := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):]
+			for x := 0; x < p.Width; x++ {
+				p.Pix[y*p.Width+x] = 0.299*float64(row[x*4]) + 0.587*float64(row[x*4+1]) + 0.114*float64(row[x*4+2])
+			}
+		}
+	default:
+		for y := 0; y < p.Height; y++ {
+			for x := 0; x < p.Width; x++ {
+				r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+				p.Pix[y*p.Width+x] = (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)) / 257
+			}
+		}
+	}
+
+	return p
+}
+
+// Chroma returns the Cb and Cr channels of img at full resolution, computed the way JPEG does.
+func Chroma(img image.Image) (*Plane, *Plane) {
+	b := img.Bounds()
+	cb, cr := NewPlane(b.Dx(), b.Dy()), NewPlane(b.Dx(), b.Dy())
+
+	for y := 0; y < cb.Height; y++ {
+		for x := 0; x < cb.Width; x++ {
+			i := y*cb.Width + x
+
+			if ycbcr, ok := img.(*image.YCbCr); ok {
+				c := ycbcr.YCbCrAt(b.Min.X+x, b.Min.Y+y)
+				cb.Pix[i], cr.Pix[i] = float64(c.Cb), float64(c.Cr)
+				continue
+			}
+
+			r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			fr, fg, fb := float64(r)/257, float64(g)/257, float64(bl)/257
+			cb.Pix[i] = -0.168736*fr - 0.331264*fg + 0.5*fb + 128
+			cr.Pix[i] = 0.5*fr - 0.418688*fg - 0.081312*fb + 128
+		}
+	}
+
+	return cb, cr
+}
+
+// MSE returns the mean squared error between a and b.
+func MSE(a, b *Plane) (float64, error) {
+	if a.Width != b.Width || a.Height != b.Height {
+		return 0, fmt.Errorf("image sizes differ: %dx%d and %dx%d", a.Width, a.Height, b.Width, b.Height)
+	}
+
+	var sum float64
+	for i := range a.Pix {
+		d := a.Pix[i] - b.Pix[i]
+		sum += d * d
+	}
+
+	return sum / float64(len(a.Pix)), nil
+}
+
+// SSIM returns the mean structural similarity of a and b.
+func SSIM(a, b *Plane) (float64, error) {
+	if err := check(a, b); err != nil {
+		return 0, err
+	}
+
+	ssim, _ := compare(a, b)
+	return ssim, nil
+}
+
+// MSSSIM returns the multi-scale structural similarity of a and b.
+// Fewer than five scales are used when the planes are too small, with the weights of the missing scales dropped.
+func MSSSIM(a, b *Plane) (float64, error) {
+	if err := check(a, b); err != nil {
+		return 0, err
+	}
+
+	var weights []float64
+	var cs []float64
+	var ssim float64
+
+	for _, w := range msssimWeights {
+		s, c := compare(a, b)
+		weights = append(weights, w)
+		cs = append(cs, c)
+		ssim = s
+
+		if a.Width/2 < window || a.Height/2 < window || len(weights) == len(msssimWeights) {
+			break
+		}
+
+		a, b = downsample(a), downsample(b)
+	}
+
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+
+	result := 1.0
+	for i, w := range weights {
+		value := cs[i]
+		if i == len(weights)-1 {
+			value = ssim
+		}
+
+		// Negative values can appear on very different images and have no meaningful power.
+		result *= math.Pow(math.Max(value, 0), w/total)
+	}
+
+	return result, nil
+}
+
+func check(a, b *Plane) error {
+	if a.Width != b.Width || a.Height != b.Height {
+		return fmt.Errorf("image sizes differ: %dx%d and %dx%d", a.Width, a.Height, b.Width, b.Height)
+	}
+
+	if a.Width < window || a.Height < window {
+		return errors.New("image is too small, at least 11x11 pixels are needed")
+	}
+
+	return nil
+}
+
+// compare returns the mean SSIM and the mean contrast-structure term of a and b.
+func compare(a, b *Plane) (float64, float64) {
+	ab := NewPlane(a.Width, a.Height)
+	aa := NewPlane(a.Width, a.Height)
+	bb := NewPlane(a.Width, a.Height)
+
+	for i := range a.Pix {
+		ab.Pix[i] = a.Pix[i] * b.Pix[i]
+		aa.Pix[i] = a.Pix[i] * a.Pix[i]
+		bb.Pix[i] = b.Pix[i] * b.Pix[i]
+	}
+
+	muA, muB := blur(a), blur(b)
+	sAA, sBB, sAB := blur(aa), blur(bb), blur(ab)
+
+	var ssim, cs float64
+	for i := range muA.Pix {
+		ma, mb := muA.Pix[i], muB.Pix[i]
+		varA := sAA.Pix[i] - ma*ma
+		varB := sBB.Pix[i] - mb*mb
+		cov := sAB.Pix[i] - ma*mb
+
+		c := (2*cov + c2) / (varA + varB + c2)
+		cs += c
+		ssim += c * (2*ma*mb + c1) / (ma*ma + mb*mb + c1)
+	}
+
+	n := float64(len(muA.Pix))
+	return ssim / n, cs / n
+}
+
+// blur filters p with the gaussian window, keeping only positions where the window fits entirely.
+func blur(p *Plane) *Plane {
+	width, height := p.Width-window+1, p.Height-window+1
+	tmp := NewPlane(width, p.Height)
+
+	for y := 0; y < p.Height; y++ {
+		row := p.Pix[y*p.Width:]
+		for x := 0; x < width; x++ {
+			var sum float64
+			for k, w := range kernel {
+				sum += w * row[x+k]
+			}
+			tmp.Pix[y*width+x] = sum
+		}
+	}
+
+	out := NewPlane(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var sum float64
+			for k, w := range kernel {
+				sum += w * tmp.Pix[(y+k)*width+x]
+			}
+			out.Pix[y*width+x] = sum
+		}
+	}
+
+	return out
+}
+
+// downsample halves p in both dimensions by averaging 2x2 blocks.
+func downsample(p *Plane) *Plane {
+	out := NewPlane(p.Width/2, p.Height/2)
+
+	for y := 0; y < out.Height; y++ {
+		for x := 0; x < out.Width; x++ {
+			i := 2*y*p.Width + 2*x
+			out.Pix[y*out.Width+x] = (p.Pix[i] + p.Pix[i+1] + p.Pix[i+p.Width] + p.Pix[i+p.Width+1]) / 4
+		}
+	}
+
+	return out
+}
+
+func gaussianKernel() []float64 {
+	k := make([]float64, window)
+	var sum float64
+
+	for i := range k {
+		d := float64(i - window/2)
+		k[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += k[i]
+	}
+
+	for i := range k {
+		k[i] /= sum
+	}
+
+	return k
+}
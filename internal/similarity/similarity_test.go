@@ -0,0 +1,51 @@
+package similarity_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin/internal/similarity"
+	"github.com/stretchr/testify/assert"
+)
+
+func gradient(noise int) image.Image {
+	img := image.NewGray(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			v := x*3 + y
+			if noise > 0 && (x*7+y*13)%5 == 0 {
+				v += noise
+			}
+			img.SetGray(x, y, color.Gray{Y: uint8(min(v, 255))})
+		}
+	}
+	return img
+}
+
+func TestSSIM(t *testing.T) {
+	a := similarity.Luma(gradient(0))
+
+	ssim, err := similarity.SSIM(a, a)
+	assert.Nil(t, err)
+	assert.InDelta(t, 1, ssim, 1e-9)
+
+	msssim, err := similarity.MSSSIM(a, a)
+	assert.Nil(t, err)
+	assert.InDelta(t, 1, msssim, 1e-9)
+
+	slight, err := similarity.SSIM(a, similarity.Luma(gradient(10)))
+	assert.Nil(t, err)
+	strong, err := similarity.SSIM(a, similarity.Luma(gradient(60)))
+	assert.Nil(t, err)
+	assert.Less(t, slight, 1.0)
+	assert.Less(t, strong, slight)
+}
+
+func TestSSIMSizeMismatch(t *testing.T) {
+	_, err := similarity.SSIM(similarity.NewPlane(20, 20), similarity.NewPlane(20, 21))
+	assert.NotNil(t, err)
+
+	_, err = similarity.SSIM(similarity.NewPlane(8, 8), similarity.NewPlane(8, 8))
+	assert.NotNil(t, err)
+}
@@ -1,9 +1,14 @@
 package mozjpegbin
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"slices"
 
 	"github.com/Munchpass/go-mozjpegbin/embedbinwrapper"
 )
@@ -15,50 +20,133 @@ type cropInfo struct {
 	height int
 }
 
+// Transform is a lossless rotation or flip applied by jpegtran.
+type Transform int
+
+const (
+	TransformNone Transform = iota
+	// TransformFlipHorizontal mirrors the image horizontally.
+	TransformFlipHorizontal
+	// TransformFlipVertical mirrors the image vertically.
+	TransformFlipVertical
+	// TransformRotate90 rotates the image 90 degrees clockwise.
+	TransformRotate90
+	TransformRotate180
+	TransformRotate270
+	// TransformTranspose mirrors the image across the diagonal from the top left corner.
+	TransformTranspose
+	// TransformTransverse mirrors the image across the diagonal from the top right corner.
+	TransformTransverse
+)
+
 // JpegTran wraps jpegtran tool from mozjpeg
 type JpegTran struct {
+	// Deprecated: BinWrapper is only set when Runner is an EmbeddedRunner, and only its settings are used:
+	// each run uses a copy of it. Use Runner instead.
 	BinWrapper  *embedbinwrapper.EmbedBinWrapper
+	Runner      Runner
 	optimize    bool
 	progressive bool
 	crop        *cropInfo
+	transform   Transform
+	perfect     bool
+	trim        bool
+	lossless    LosslessPolicy
 	inputFile   string
 	input       io.Reader
 	outputFile  string
 	output      io.Writer
 	copy        string
+	metadata    *MetadataPolicy
+	thumbnail   ThumbnailMode
+	toolchain   *Toolchain
+	err         error
+	result      *TransformResult
+}
+
+// TransformResult describes the output of a JpegTran run.
+type TransformResult struct {
+	// Size is the size of the output in bytes.
+	Size int
+	// Scans are the scans of the output, several for a progressive one. It's nil if the output couldn't be parsed.
+	Scans []ScanInfo
+	// Path tells how the output was made when Lossless is used.
+	Path TransformPath
+	// Width and Height are the dimensions of the output when Lossless is used, zero otherwise.
+	Width  int
+	Height int
+	// Quality is the quality the output was encoded with when it was reencoded by Lossless, zero otherwise.
+	Quality int
 }
 
-// NewJpegTran creates new JpegTran instance
+// NewJpegTran creates new JpegTran instance using DefaultRunner
 func NewJpegTran() (*JpegTran, error) {
-	binWrapper, err := createBinWrapper("jpegtran")
+	runner, err := defaultRunnerFor("jpegtran")
 	if err != nil {
-		return nil, fmt.Errorf("failed to create bin wrapper: %v", err)
+		return nil, err
 	}
 
-	bin := &JpegTran{
-		BinWrapper: binWrapper,
+	return NewJpegTranWithRunner(runner), nil
+}
+
+// NewJpegTranWithRunner creates new JpegTran instance running jpegtran with runner
+func NewJpegTranWithRunner(runner Runner) *JpegTran {
+	return &JpegTran{
+		BinWrapper: embeddedBinWrapper(runner, "jpegtran"),
+		Runner:     runner,
 		copy:       "none",
 		optimize:   true,
 	}
+}
 
-	return bin, nil
+// runner returns the Runner to run jpegtran with, honoring BinWrapper.
+func (c *JpegTran) runner() Runner {
+	return withBinWrapper(c.Runner, "jpegtran", c.BinWrapper)
 }
 
 // Optimize perform optimization of entropy encoding parameters
 func (c *JpegTran) Optimize(optimize bool) *JpegTran {
 	c.optimize = optimize
+	c.checkToolchain()
 	return c
 }
 
 // Progressive create progressive JPEG file
 func (c *JpegTran) Progressive(progressive bool) *JpegTran {
 	c.progressive = progressive
+	c.checkToolchain()
 	return c
 }
 
-// Crop to a rectangular region of width and height, starting at point x,y
+// Crop to a rectangular region of width and height, starting at point x,y.
+// The region is relative to the image after Transform.
 func (c *JpegTran) Crop(x, y, width, height int) *JpegTran {
 	c.crop = &cropInfo{x, y, width, height}
+	c.checkToolchain()
+	return c
+}
+
+// Transform rotates or flips the image.
+// Unless Perfect, Trim or Lossless is used, partial iMCUs on the edges that would move are left untransformed.
+// Progressive inputs whose width and height are swapped are transformed with jpegtran -revert -progressive,
+// as mozjpeg's jpegtran would keep their dimensions. Outputs are then about 2% larger, without scan optimization.
+func (c *JpegTran) Transform(t Transform) *JpegTran {
+	c.transform = t
+	c.checkToolchain()
+	return c
+}
+
+// Perfect makes Run fail if Transform can't be done perfectly because of partial iMCUs on the edges.
+func (c *JpegTran) Perfect(perfect bool) *JpegTran {
+	c.perfect = perfect
+	c.checkToolchain()
+	return c
+}
+
+// Trim drops partial iMCUs on the edges that Transform can't move, so the output may be smaller than the input.
+func (c *JpegTran) Trim(trim bool) *JpegTran {
+	c.trim = trim
+	c.checkToolchain()
 	return c
 }
 
@@ -97,39 +185,95 @@ func (c *JpegTran) Output(writer io.Writer) *JpegTran {
 // CopyNone copy no extra markers from source file. This setting suppresses all comments and other metadata in the source file
 func (c *JpegTran) CopyNone() *JpegTran {
 	c.copy = "none"
+	c.metadata = nil
+	c.checkToolchain()
 	return c
 }
 
 // CopyComments copy only comment markers.  This setting copies comments from the source file but discards any other metadata.
 func (c *JpegTran) CopyComments() *JpegTran {
 	c.copy = "comments"
+	c.metadata = nil
+	c.checkToolchain()
 	return c
 }
 
-// CopyAll copy all extra markers. This setting preserves miscellaneous markers found in the source file, such as JFIF thumbnails, Exif data, and Photoshop settings. In some files, these extra markers can be sizable. Note that this option will copy thumbnails as-is; they will not be transformed.
+// CopyAll copy all extra markers. This setting preserves miscellaneous markers found in the source file, such as JFIF thumbnails, Exif data, and Photoshop settings. In some files, these extra markers can be sizable. Note that this option will copy thumbnails as-is; they will not be transformed unless Thumbnail asks for it.
 func (c *JpegTran) CopyAll() *JpegTran {
 	c.copy = "all"
+	c.metadata = nil
+	c.checkToolchain()
+	return c
+}
+
+// Metadata copies the metadata selected by policy to the output, with EXIF data filtered tag by tag.
+// CopyNone, CopyComments or CopyAll called before will be ignored.
+func (c *JpegTran) Metadata(policy *MetadataPolicy) *JpegTran {
+	c.copy = "none"
+	c.metadata = copyPolicy(policy)
+	c.checkToolchain()
+	return c
+}
+
+// Thumbnail sets what happens to the EXIF thumbnail copied with CopyAll or Metadata.
+// ThumbnailRegenerate makes a new thumbnail from the output with djpeg and cjpeg, so that it matches a cropped image.
+// With ThumbnailRegenerate or ThumbnailRemove, the dimensions recorded in the EXIF data are updated as well.
+func (c *JpegTran) Thumbnail(mode ThumbnailMode) *JpegTran {
+	c.thumbnail = mode
 	return c
 }
 
+// Toolchain makes JpegTran check that the jpegtran described by t supports the switches selected by the builder methods,
+// like Crop. The first unsupported one is reported by Err, and makes Run fail without running anything.
+// nil, the default, disables the checks.
+func (c *JpegTran) Toolchain(t *Toolchain) *JpegTran {
+	c.toolchain = t
+	c.checkToolchain()
+	return c
+}
+
+// Err returns the error Run would fail with because of the options set, like an *UnsupportedFeatureError.
+func (c *JpegTran) Err() error {
+	return c.err
+}
+
+func (c *JpegTran) checkToolchain() {
+	c.err = nil
+	if c.toolchain != nil {
+		c.err = c.toolchain.check("jpegtran", switches(c.args(true))...)
+	}
+}
+
 // Run starts jpegtran with specified parameters.
 func (c *JpegTran) Run() error {
-	defer c.BinWrapper.Reset()
+	return c.RunContext(context.Background())
+}
 
-	if c.optimize {
-		c.BinWrapper.Arg("-optimize")
+// RunContext starts jpegtran with specified parameters. jpegtran is stopped when ctx is done.
+func (c *JpegTran) RunContext(ctx context.Context) error {
+	c.result = nil
+
+	if c.err != nil {
+		return c.err
 	}
 
-	if c.progressive {
-		c.BinWrapper.Arg("-progressive")
+	if c.lossless != LosslessOff {
+		return c.runLossless(ctx)
 	}
 
-	if c.crop != nil {
-		c.BinWrapper.Arg("-crop",
-			fmt.Sprintf("%dx%d+%d+%d", c.crop.width, c.crop.height, c.crop.x, c.crop.y))
+	var progressive bool
+	if c.transform.swaps() {
+		var err error
+		if progressive, err = c.progressiveInput(); err != nil {
+			return err
+		}
 	}
 
-	c.BinWrapper.Arg("-copy", c.copy)
+	inv := &Invocation{Tool: "jpegtran", Args: c.args(progressive)}
+
+	if c.metadata != nil || c.thumbnail != ThumbnailCopy {
+		return c.runBuffered(ctx, inv)
+	}
 
 	output, err := c.getOutput()
 
@@ -138,31 +282,138 @@ func (c *JpegTran) Run() error {
 	}
 
 	if output != "" {
-		c.BinWrapper.Arg("-outfile", output)
+		inv.Args = append(inv.Args, "-outfile", output)
 	}
 
-	err = c.setInput()
+	err = c.setInput(inv)
 
 	if err != nil {
 		return err
 	}
 
+	var counter *countingWriter
+	var idx scanIndexer
 	if c.output != nil {
-		c.BinWrapper.SetStdOut(c.output)
+		counter = &countingWriter{w: c.output}
+		inv.Stdout = io.MultiWriter(counter, &idx)
+	}
+
+	if _, err = runTool(ctx, c.runner(), inv); err != nil {
+		return err
+	}
+
+	c.result = &TransformResult{}
+
+	if counter != nil {
+		c.result.Size = counter.n
+		c.result.Scans, _ = idx.result()
+	} else if info, err := os.Stat(output); err == nil {
+		c.result.Size = int(info.Size())
+		c.result.Scans, _ = scanFileIndex(output)
+	}
+
+	return nil
+}
+
+// Result returns the outcome of the last successful Run, or nil.
+func (c *JpegTran) Result() *TransformResult {
+	return c.result
+}
+
+// args returns the switches selected by the builder methods for an input that is progressive or not.
+func (c *JpegTran) args(progressive bool) []string {
+	var args []string
+
+	// mozjpeg jpegtran keeps the dimensions of progressive inputs when their width and height are swapped,
+	// unless it reverts to the libjpeg defaults. Progressive output, its default, is then asked for explicitly.
+	revert := progressive && c.transform.swaps()
+	if revert {
+		args = append(args, "-revert")
+	}
+
+	if c.optimize {
+		args = append(args, "-optimize")
 	}
 
-	err = c.BinWrapper.Run()
+	if c.progressive || revert {
+		args = append(args, "-progressive")
+	}
+
+	if c.crop != nil {
+		args = append(args, "-crop",
+			fmt.Sprintf("%dx%d+%d+%d", c.crop.width, c.crop.height, c.crop.x, c.crop.y))
+	}
+
+	args = append(args, c.transform.args()...)
+
+	if c.perfect {
+		args = append(args, "-perfect")
+	}
+
+	if c.trim {
+		args = append(args, "-trim")
+	}
+
+	return append(args, "-copy", c.copy)
+}
+
+// runBuffered runs inv on the input held in memory, then applies the MetadataPolicy and the ThumbnailMode.
+func (c *JpegTran) runBuffered(ctx context.Context, inv *Invocation) error {
+	output, err := c.getOutput()
 
 	if err != nil {
-		return errors.New(err.Error() + ". " + string(c.BinWrapper.StdErr()))
+		return err
+	}
+
+	source, err := c.readInput()
+
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	inv.Stdin = bytes.NewReader(source)
+	inv.Stdout = &out
+
+	if _, err := runTool(ctx, c.runner(), inv); err != nil {
+		return err
+	}
+
+	data, err := c.postProcess(ctx, c.metadata, source, out.Bytes())
+	if err != nil {
+		return err
 	}
 
+	if err := writeTo(data, c.output, output); err != nil {
+		return err
+	}
+
+	c.result = &TransformResult{Size: len(data), Scans: indexScans(data)}
 	return nil
 }
 
+// postProcess applies policy, if not nil, and the ThumbnailMode to data, transformed from source.
+func (c *JpegTran) postProcess(ctx context.Context, policy *MetadataPolicy, source, data []byte) ([]byte, error) {
+	var err error
+
+	if policy != nil {
+		if data, err = applyMetadata(policy, source, data); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.thumbnail != ThumbnailCopy {
+		if data, err = updateExif(ctx, c.Runner, data, c.thumbnail); err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
 // Version returns jpegtran version.
 func (c *JpegTran) Version() (string, error) {
-	return version(c.BinWrapper)
+	return version(c.runner(), "jpegtran")
 }
 
 // Reset resets all parameters to default values
@@ -170,15 +421,22 @@ func (c *JpegTran) Reset() *JpegTran {
 	c.optimize = true
 	c.progressive = false
 	c.copy = "none"
+	c.metadata = nil
+	c.thumbnail = ThumbnailCopy
 	c.crop = nil
+	c.transform = TransformNone
+	c.perfect = false
+	c.trim = false
+	c.lossless = LosslessOff
+	c.checkToolchain()
 	return c
 }
 
-func (c *JpegTran) setInput() error {
+func (c *JpegTran) setInput(inv *Invocation) error {
 	if c.input != nil {
-		c.BinWrapper.StdIn(c.input)
+		inv.Stdin = c.input
 	} else if c.inputFile != "" {
-		c.BinWrapper.Arg(c.inputFile)
+		inv.Args = append(inv.Args, c.inputFile)
 	} else {
 		return errors.New("undefined input")
 	}
@@ -186,6 +444,45 @@ func (c *JpegTran) setInput() error {
 	return nil
 }
 
+// progressiveInput reports whether the input is a progressive JPEG file, reading only its header.
+// A streamed input stays complete. Invalid inputs are left to jpegtran to report.
+func (c *JpegTran) progressiveInput() (bool, error) {
+	var r io.Reader
+	if c.input != nil {
+		var head bytes.Buffer
+		r = bufio.NewReader(io.TeeReader(c.input, &head))
+		defer func() { c.input = io.MultiReader(&head, c.input) }()
+	} else if c.inputFile != "" {
+		f, err := os.Open(c.inputFile)
+		if err != nil {
+			return false, err
+		}
+
+		defer f.Close()
+		r = bufio.NewReader(f)
+	} else {
+		return false, errors.New("undefined input")
+	}
+
+	segments, err := readHeader(r)
+	if err != nil {
+		return false, nil
+	}
+
+	return slices.ContainsFunc(segments, func(s segment) bool { return isProgressiveSOF(s.marker) }), nil
+}
+
+// readInput reads the whole input.
+func (c *JpegTran) readInput() ([]byte, error) {
+	if c.input != nil {
+		return io.ReadAll(c.input)
+	} else if c.inputFile != "" {
+		return os.ReadFile(c.inputFile)
+	}
+
+	return nil, errors.New("undefined input")
+}
+
 func (c *JpegTran) getOutput() (string, error) {
 	if c.output != nil {
 		return "", nil
@@ -1,7 +1,9 @@
 package mozjpegbin_test
 
 import (
+	"bytes"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/Munchpass/go-mozjpegbin"
@@ -87,3 +89,84 @@ func TestJpegTranVersion(t *testing.T) {
 	}
 	assert.NotZero(t, v)
 }
+
+func TestJpegTranTransformProgressive(t *testing.T) {
+	// jpegtran outputs are progressive.
+	progressive, _, err := transformSource(t, func(c *mozjpegbin.JpegTran) {})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	c, err := mozjpegbin.NewJpegTran()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var out bytes.Buffer
+	err = c.Transform(mozjpegbin.TransformRotate270).Input(bytes.NewReader(progressive)).Output(&out).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	info, err := mozjpegbin.Inspect(&out)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, 901, info.Width)
+	assert.Equal(t, 1203, info.Height)
+	assert.True(t, info.Progressive)
+
+	// Files are sniffed too.
+	name := filepath.Join(t.TempDir(), "progressive.jpg")
+	if !assert.Nil(t, os.WriteFile(name, progressive, 0o644)) {
+		t.FailNow()
+	}
+
+	out.Reset()
+	err = c.InputFile(name).Output(&out).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	info, err = mozjpegbin.Inspect(&out)
+	if assert.Nil(t, err) {
+		assert.Equal(t, 901, info.Width)
+		assert.Equal(t, 1203, info.Height)
+	}
+}
+
+func TestJpegTranTransformBaseline(t *testing.T) {
+	rotated, _, err := transformSource(t, func(c *mozjpegbin.JpegTran) { c.Transform(mozjpegbin.TransformRotate90) })
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	info, err := mozjpegbin.Inspect(bytes.NewReader(rotated))
+	if assert.Nil(t, err) {
+		assert.Equal(t, 901, info.Width)
+		assert.Equal(t, 1203, info.Height)
+	}
+
+	progressive, _, err := transformSource(t, func(c *mozjpegbin.JpegTran) {})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	c, err := mozjpegbin.NewJpegTran()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var reverted bytes.Buffer
+	err = c.Input(bytes.NewReader(progressive)).Output(&reverted).Transform(mozjpegbin.TransformRotate90).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	// Baseline inputs are rotated without -revert, keeping mozjpeg's scan optimization.
+	// CgoRunner ignores -revert.
+	if _, ok := c.Runner.(*mozjpegbin.EmbeddedRunner); ok {
+		assert.Less(t, len(rotated), reverted.Len())
+	}
+}
@@ -0,0 +1,434 @@
+package mozjpegbin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+)
+
+// ErrNotPerfect is returned by LosslessStrict when a transform can't be done losslessly with the exact dimensions requested.
+var ErrNotPerfect = errors.New("transform is not perfect")
+
+// LosslessPolicy tells what JpegTran does when a transform can't be done losslessly.
+type LosslessPolicy int
+
+const (
+	// LosslessOff runs jpegtran as configured.
+	LosslessOff LosslessPolicy = iota
+	// LosslessStrict fails with ErrNotPerfect.
+	LosslessStrict
+	// LosslessTrim falls back to jpegtran -trim, which drops the partial iMCUs on the edges that would move.
+	// The output is still lossless, but may be smaller than requested.
+	LosslessTrim
+	// LosslessReencode falls back to decoding the input with djpeg, transforming it in Go
+	// and encoding it again with cjpeg at the estimated quality of the input.
+	LosslessReencode
+	// LosslessExact falls back to jpegtran -trim when the output still has the requested dimensions,
+	// which happens when the crop doesn't cover the trimmed edges, and to reencoding otherwise.
+	LosslessExact
+)
+
+// TransformPath tells how a JpegTran output was made.
+type TransformPath int
+
+const (
+	// PathDirect means jpegtran ran as configured, without Lossless.
+	PathDirect TransformPath = iota
+	// PathLossless means jpegtran -perfect succeeded.
+	PathLossless
+	// PathTrimmed means jpegtran -trim was used.
+	PathTrimmed
+	// PathReencoded means the input was decoded, transformed and encoded again.
+	PathReencoded
+)
+
+// String returns the name of the path.
+func (p TransformPath) String() string {
+	switch p {
+	case PathDirect:
+		return "direct"
+	case PathLossless:
+		return "lossless"
+	case PathTrimmed:
+		return "trimmed"
+	case PathReencoded:
+		return "reencoded"
+	default:
+		return "unknown"
+	}
+}
+
+// Lossless makes Run try Transform and Crop with jpegtran -perfect first, and follow policy if the output
+// wouldn't be lossless with exactly the requested dimensions, because of partial iMCUs on the edges
+// or because the crop doesn't start on an iMCU boundary. Result reports the path taken and the dimensions of the output.
+// Perfect and Trim are ignored. The input is held in memory.
+//
+// A reencoded output keeps the chroma subsampling of the input and the metadata selected by the copy mode,
+// or by Metadata: CopyAll keeps the metadata Metadata can keep, other APPn segments are dropped.
+func (c *JpegTran) Lossless(policy LosslessPolicy) *JpegTran {
+	c.lossless = policy
+	return c
+}
+
+// runLossless runs jpegtran following Lossless.
+func (c *JpegTran) runLossless(ctx context.Context) error {
+	output, err := c.getOutput()
+	if err != nil {
+		return err
+	}
+
+	source, err := c.readInput()
+	if err != nil {
+		return err
+	}
+
+	info, err := Inspect(bytes.NewReader(source))
+	if err != nil {
+		return err
+	}
+
+	g := c.transform.geometry(info)
+	rect := g.bounds
+	if c.crop != nil {
+		rect = image.Rect(c.crop.x, c.crop.y, c.crop.x+c.crop.width, c.crop.y+c.crop.height)
+		if rect.Empty() || !rect.In(g.bounds) {
+			return fmt.Errorf("%w: %v crop of %dx%d image", ErrCropOutOfBounds, rect, g.bounds.Dx(), g.bounds.Dy())
+		}
+	}
+
+	result := &TransformResult{}
+	data, err := c.transformLossless(ctx, source, info, g, rect, result)
+	if err != nil {
+		return err
+	}
+
+	policy := c.metadata
+	if result.Path == PathReencoded && policy == nil {
+		policy = copyModePolicy(c.copy)
+	}
+
+	if data, err = c.postProcess(ctx, policy, source, data); err != nil {
+		return err
+	}
+
+	if err := writeTo(data, c.output, output); err != nil {
+		return err
+	}
+
+	out, err := Inspect(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	result.Size = len(data)
+	result.Scans = indexScans(data)
+	result.Width, result.Height = out.Width, out.Height
+	c.result = result
+	return nil
+}
+
+// transformLossless transforms source and crops it to rect, following the LosslessPolicy. It sets the Path of result.
+func (c *JpegTran) transformLossless(ctx context.Context, source []byte, info *Info, g *transformGeometry, rect image.Rectangle, result *TransformResult) ([]byte, error) {
+	aligned := c.crop == nil || g.aligned(rect)
+	if aligned {
+		data, err := c.runTrial(ctx, source, info, rect, true, false)
+		if err == nil {
+			result.Path = PathLossless
+			return data, nil
+		} else if g.perfect {
+			return nil, err
+		}
+	}
+
+	// With -trim, the transformed image is shifted by the trimmed edges.
+	trimmed := rect.Sub(g.offset)
+	exact := c.crop != nil && trimmed.In(g.trimmed) && g.aligned(trimmed)
+
+	switch {
+	case c.lossless == LosslessStrict && !aligned:
+		return nil, fmt.Errorf("%w: crop origin %v isn't on the %dx%d iMCU grid", ErrNotPerfect, rect.Min, g.imcu.X, g.imcu.Y)
+	case c.lossless == LosslessStrict:
+		return nil, fmt.Errorf("%w: partial iMCUs on the edges of %dx%d image", ErrNotPerfect, info.Width, info.Height)
+	case c.lossless == LosslessTrim || c.lossless == LosslessExact && exact:
+		if c.crop != nil {
+			if trimmed = trimmed.Intersect(g.trimmed); trimmed.Empty() {
+				return nil, fmt.Errorf("%w: %v crop is within the trimmed edges", ErrCropOutOfBounds, rect)
+			}
+		}
+
+		result.Path = PathTrimmed
+		return c.runTrial(ctx, source, info, trimmed, false, true)
+	}
+
+	result.Path = PathReencoded
+	return c.reencode(ctx, source, info, rect, result)
+}
+
+// runTrial runs jpegtran on source, described by info, with the settings of c, cropping to rect unless no crop was requested.
+func (c *JpegTran) runTrial(ctx context.Context, source []byte, info *Info, rect image.Rectangle, perfect, trim bool) ([]byte, error) {
+	trial := *c
+	trial.perfect, trial.trim = perfect, trim
+	if c.crop != nil {
+		trial.crop = &cropInfo{rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy()}
+	}
+
+	var out bytes.Buffer
+	_, err := runTool(ctx, c.runner(), &Invocation{
+		Tool:   "jpegtran",
+		Args:   trial.args(info.Progressive),
+		Stdin:  bytes.NewReader(source),
+		Stdout: &out,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// reencode decodes source with djpeg, transforms and crops it to rect in Go, and encodes it with cjpeg
+// at the estimated quality and with the subsampling of source. It sets the Quality of result.
+func (c *JpegTran) reencode(ctx context.Context, source []byte, info *Info, rect image.Rectangle, result *TransformResult) ([]byte, error) {
+	quality, err := estimateJPEGQuality(source)
+	if err != nil {
+		return nil, err
+	}
+
+	var pnm bytes.Buffer
+	_, err = runTool(ctx, c.runner(), &Invocation{
+		Tool:   "djpeg",
+		Args:   []string{"-pnm"},
+		Stdin:  bytes.NewReader(source),
+		Stdout: &pnm,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := readPNM(&pnm)
+	if err != nil {
+		return nil, err
+	}
+
+	img = c.transform.apply(img)
+	if sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		img = sub.SubImage(rect)
+	}
+
+	pnm.Reset()
+	if err := writePNM(&pnm, img); err != nil {
+		return nil, err
+	}
+
+	enc := NewCJpegWithRunner(c.Runner).Quality(uint(quality))
+	if h, v, ok := chromaSampling(info); ok {
+		if c.transform.swaps() {
+			h, v = v, h
+		}
+
+		enc.sample = fmt.Sprintf("%dx%d", h, v)
+	}
+
+	var out bytes.Buffer
+	if err := enc.Input(&pnm).Output(&out).RunContext(ctx); err != nil {
+		return nil, err
+	}
+
+	result.Quality = quality
+	return out.Bytes(), nil
+}
+
+// chromaSampling returns the sampling factors of the luma component of a color file
+// whose chroma components aren't subsampled themselves.
+func chromaSampling(info *Info) (int, int, bool) {
+	if len(info.Components) != 3 {
+		return 0, 0, false
+	}
+
+	for _, component := range info.Components[1:] {
+		if component.H != 1 || component.V != 1 {
+			return 0, 0, false
+		}
+	}
+
+	return info.Components[0].H, info.Components[0].V, true
+}
+
+// copyModePolicy returns the MetadataPolicy closest to the jpegtran copy mode.
+func copyModePolicy(mode string) *MetadataPolicy {
+	switch mode {
+	case "all":
+		return &MetadataPolicy{
+			KeepICC:       true,
+			KeepXMP:       true,
+			KeepPhotoshop: true,
+			KeepComments:  true,
+			KeepExif:      true,
+			KeepGPS:       true,
+			KeepMakerNote: true,
+			KeepThumbnail: true,
+		}
+	case "comments":
+		return &MetadataPolicy{KeepComments: true}
+	default:
+		return &MetadataPolicy{}
+	}
+}
+
+// transformGeometry describes a transform of an image, in the coordinates of the transformed image.
+type transformGeometry struct {
+	// bounds are the bounds of the transformed image.
+	bounds image.Rectangle
+	// imcu is the size of the iMCUs of the transformed image.
+	imcu image.Point
+	// perfect reports whether no partial iMCU on the edges has to move.
+	perfect bool
+	// offset is where the image trimmed by -trim starts in bounds.
+	offset image.Point
+	// trimmed are the bounds of the image transformed with -trim.
+	trimmed image.Rectangle
+}
+
+// aligned reports whether r starts on the iMCU grid.
+func (g *transformGeometry) aligned(r image.Rectangle) bool {
+	return r.Min.X%g.imcu.X == 0 && r.Min.Y%g.imcu.Y == 0
+}
+
+// geometry returns the geometry of t applied to the file described by info.
+func (t Transform) geometry(info *Info) *transformGeometry {
+	imcu := info.IMCU()
+	rw, rh := info.Width%imcu.X, info.Height%imcu.Y
+
+	// Partial iMCUs on the right or bottom edge only have to move if the transform moves that edge.
+	switch t {
+	case TransformFlipHorizontal, TransformRotate270:
+		rh = 0
+	case TransformFlipVertical, TransformRotate90:
+		rw = 0
+	case TransformRotate180, TransformTransverse:
+	default:
+		rw, rh = 0, 0
+	}
+
+	g := &transformGeometry{
+		bounds:  image.Rect(0, 0, info.Width, info.Height),
+		imcu:    imcu,
+		perfect: rw == 0 && rh == 0,
+		trimmed: image.Rect(0, 0, info.Width-rw, info.Height-rh),
+	}
+
+	switch t {
+	case TransformFlipHorizontal:
+		g.offset = image.Pt(rw, 0)
+	case TransformFlipVertical:
+		g.offset = image.Pt(0, rh)
+	case TransformRotate180:
+		g.offset = image.Pt(rw, rh)
+	case TransformRotate90:
+		g.offset = image.Pt(rh, 0)
+	case TransformRotate270:
+		g.offset = image.Pt(0, rw)
+	case TransformTransverse:
+		g.offset = image.Pt(rh, rw)
+	}
+
+	if t.swaps() {
+		g.bounds = image.Rect(0, 0, info.Height, info.Width)
+		g.imcu = image.Pt(imcu.Y, imcu.X)
+		g.trimmed = image.Rect(0, 0, g.trimmed.Dy(), g.trimmed.Dx())
+	}
+
+	return g
+}
+
+// args returns the jpegtran switches of t.
+func (t Transform) args() []string {
+	switch t {
+	case TransformFlipHorizontal:
+		return []string{"-flip", "horizontal"}
+	case TransformFlipVertical:
+		return []string{"-flip", "vertical"}
+	case TransformRotate90:
+		return []string{"-rotate", "90"}
+	case TransformRotate180:
+		return []string{"-rotate", "180"}
+	case TransformRotate270:
+		return []string{"-rotate", "270"}
+	case TransformTranspose:
+		return []string{"-transpose"}
+	case TransformTransverse:
+		return []string{"-transverse"}
+	default:
+		return nil
+	}
+}
+
+// swaps reports whether t swaps the width and height of images.
+func (t Transform) swaps() bool {
+	return t == TransformRotate90 || t == TransformRotate270 || t == TransformTranspose || t == TransformTransverse
+}
+
+// source returns the pixel of an image of width x height that t moves to x, y.
+func (t Transform) source(x, y, width, height int) (int, int) {
+	switch t {
+	case TransformFlipHorizontal:
+		return width - 1 - x, y
+	case TransformFlipVertical:
+		return x, height - 1 - y
+	case TransformRotate90:
+		return y, height - 1 - x
+	case TransformRotate180:
+		return width - 1 - x, height - 1 - y
+	case TransformRotate270:
+		return width - 1 - y, x
+	case TransformTranspose:
+		return y, x
+	case TransformTransverse:
+		return width - 1 - y, height - 1 - x
+	default:
+		return x, y
+	}
+}
+
+// apply returns img transformed by t. Images other than *image.Gray are converted to *image.RGBA.
+func (t Transform) apply(img image.Image) image.Image {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if t == TransformNone {
+		return img
+	}
+
+	outWidth, outHeight := width, height
+	if t.swaps() {
+		outWidth, outHeight = height, width
+	}
+
+	var src, dst []byte
+	var srcOffset, dstOffset func(x, y int) int
+	var out image.Image
+	size := 4
+
+	if gray, ok := img.(*image.Gray); ok {
+		o := image.NewGray(image.Rect(0, 0, outWidth, outHeight))
+		src, srcOffset, dst, dstOffset, out = gray.Pix, gray.PixOffset, o.Pix, o.PixOffset, o
+		size = 1
+	} else {
+		rgba := toRGBA(img)
+		o := image.NewRGBA(image.Rect(0, 0, outWidth, outHeight))
+		src, srcOffset, dst, dstOffset, out = rgba.Pix, rgba.PixOffset, o.Pix, o.PixOffset, o
+	}
+
+	for y := 0; y < outHeight; y++ {
+		for x := 0; x < outWidth; x++ {
+			sx, sy := t.source(x, y, width, height)
+			i := srcOffset(b.Min.X+sx, b.Min.Y+sy)
+			copy(dst[dstOffset(x, y):][:size], src[i:i+size])
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,139 @@
+package mozjpegbin_test
+
+import (
+	"bytes"
+	"errors"
+	"image/jpeg"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/Munchpass/go-mozjpegbin/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+// transformSource runs a jpegtran set up by setup on source.jpg and returns the output.
+func transformSource(t *testing.T, setup func(c *mozjpegbin.JpegTran)) ([]byte, *mozjpegbin.TransformResult, error) {
+	c, err := mozjpegbin.NewJpegTran()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var out bytes.Buffer
+	setup(c.InputFile("source.jpg").Output(&out))
+	err = c.Run()
+	return out.Bytes(), c.Result(), err
+}
+
+func TestJpegTranLosslessPerfect(t *testing.T) {
+	// 1200x896 is a whole number of 16x16 iMCUs.
+	aligned, _, err := transformSource(t, func(c *mozjpegbin.JpegTran) {
+		c.Crop(0, 0, 1200, 896)
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	c, err := mozjpegbin.NewJpegTran()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var out bytes.Buffer
+	err = c.Input(bytes.NewReader(aligned)).
+		Output(&out).
+		Transform(mozjpegbin.TransformRotate90).
+		Lossless(mozjpegbin.LosslessStrict).
+		Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	result := c.Result()
+	assert.Equal(t, mozjpegbin.PathLossless, result.Path)
+	assert.Equal(t, 896, result.Width)
+	assert.Equal(t, 1200, result.Height)
+	assert.Zero(t, result.Quality)
+	assert.Equal(t, out.Len(), result.Size)
+}
+
+func TestJpegTranLosslessStrict(t *testing.T) {
+	_, result, err := transformSource(t, func(c *mozjpegbin.JpegTran) {
+		c.Transform(mozjpegbin.TransformRotate90).Lossless(mozjpegbin.LosslessStrict)
+	})
+	assert.True(t, errors.Is(err, mozjpegbin.ErrNotPerfect), "%v", err)
+	assert.Nil(t, result)
+
+	// The origin of the crop isn't on the iMCU grid.
+	_, _, err = transformSource(t, func(c *mozjpegbin.JpegTran) {
+		c.Crop(8, 0, 100, 100).Lossless(mozjpegbin.LosslessStrict)
+	})
+	assert.True(t, errors.Is(err, mozjpegbin.ErrNotPerfect), "%v", err)
+
+	_, _, err = transformSource(t, func(c *mozjpegbin.JpegTran) {
+		c.Transform(mozjpegbin.TransformRotate90).Crop(800, 0, 200, 100).Lossless(mozjpegbin.LosslessStrict)
+	})
+	assert.True(t, errors.Is(err, mozjpegbin.ErrCropOutOfBounds), "%v", err)
+}
+
+func TestJpegTranLosslessTrim(t *testing.T) {
+	_, result, err := transformSource(t, func(c *mozjpegbin.JpegTran) {
+		c.Transform(mozjpegbin.TransformFlipHorizontal).Lossless(mozjpegbin.LosslessTrim)
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, mozjpegbin.PathTrimmed, result.Path)
+	assert.Equal(t, 1200, result.Width)
+	assert.Equal(t, 901, result.Height)
+}
+
+func TestJpegTranLosslessExact(t *testing.T) {
+	// Rotated, the image is 901x1203 and its 5 bottom rows become the 5 leftmost columns, which -trim drops.
+	// Shifted by them, this crop starts on the iMCU grid, so it's done losslessly.
+	trimmed, result, err := transformSource(t, func(c *mozjpegbin.JpegTran) {
+		c.Transform(mozjpegbin.TransformRotate90).Crop(21, 16, 200, 100).Lossless(mozjpegbin.LosslessExact)
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, mozjpegbin.PathTrimmed, result.Path)
+	assert.Equal(t, 200, result.Width)
+	assert.Equal(t, 100, result.Height)
+
+	reencoded, result, err := transformSource(t, func(c *mozjpegbin.JpegTran) {
+		c.Transform(mozjpegbin.TransformRotate90).Crop(21, 16, 200, 100).Lossless(mozjpegbin.LosslessReencode)
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, mozjpegbin.PathReencoded, result.Path)
+	assert.Equal(t, 200, result.Width)
+	assert.Equal(t, 100, result.Height)
+	assert.Greater(t, result.Quality, 0)
+
+	// Both paths produce the same pixels.
+	img, err := jpeg.Decode(bytes.NewReader(trimmed))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	comparison, err := metrics.CompareJPEG(img, reencoded)
+	if assert.Nil(t, err) {
+		assert.Greater(t, comparison.PSNR, 35.0)
+	}
+
+	// This one can't be done losslessly.
+	_, result, err = transformSource(t, func(c *mozjpegbin.JpegTran) {
+		c.Transform(mozjpegbin.TransformRotate90).Crop(16, 16, 200, 100).Lossless(mozjpegbin.LosslessExact)
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, mozjpegbin.PathReencoded, result.Path)
+	assert.Equal(t, 200, result.Width)
+	assert.Equal(t, 100, result.Height)
+}
@@ -0,0 +1,163 @@
+package mozjpegbin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// JPEG markers used across the package.
+const (
+	markerSOI   = 0xD8
+	markerEOI   = 0xD9
+	markerSOS   = 0xDA
+	markerDQT   = 0xDB
+	markerDRI   = 0xDD
+	markerAPP0  = 0xE0
+	markerAPP1  = 0xE1
+	markerAPP2  = 0xE2
+	markerAPP13 = 0xED
+	markerAPP14 = 0xEE
+	markerCOM   = 0xFE
+)
+
+// isProgressiveSOF reports whether marker starts the frame of a progressive file.
+func isProgressiveSOF(marker byte) bool {
+	return marker == 0xC2 || marker == 0xC6 || marker == 0xCA || marker == 0xCE
+}
+
+// segment is a JPEG marker segment found before the first scan.
+type segment struct {
+	// marker is the second byte of the marker, e.g. 0xE1 for APP1.
+	marker byte
+	// data is the payload, without the marker and the length.
+	data []byte
+}
+
+// isAPP reports whether s is an application segment.
+func (s segment) isAPP() bool {
+	return s.marker >= markerAPP0 && s.marker <= markerAPP0+15
+}
+
+// hasPrefix reports whether the payload of s starts with prefix, e.g. "Exif\x00\x00".
+func (s segment) hasPrefix(prefix string) bool {
+	return bytes.HasPrefix(s.data, []byte(prefix))
+}
+
+// splitHeader splits a JPEG file into the marker segments preceding the first scan and the rest of the file,
+// which starts with the SOS marker.
+func splitHeader(data []byte) ([]segment, []byte, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != markerSOI {
+		return nil, nil, errors.New("not a jpeg file: missing SOI marker")
+	}
+
+	var segments []segment
+	pos := 2
+
+	for {
+		// Markers may be preceded by any number of fill bytes.
+		for pos < len(data) && data[pos] == 0xFF && pos+1 < len(data) && data[pos+1] == 0xFF {
+			pos++
+		}
+
+		if pos+4 > len(data) || data[pos] != 0xFF {
+			return nil, nil, fmt.Errorf("invalid jpeg marker at offset %d", pos)
+		}
+
+		marker := data[pos+1]
+
+		if marker == markerSOS {
+			return segments, data[pos:], nil
+		}
+
+		if marker == markerEOI {
+			return nil, nil, errors.New("invalid jpeg: no scan found")
+		}
+
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil, nil, fmt.Errorf("invalid length of jpeg marker 0x%X at offset %d", marker, pos)
+		}
+
+		segments = append(segments, segment{marker: marker, data: data[pos+4 : pos+2+length]})
+		pos += 2 + length
+	}
+}
+
+// readHeader reads the segments of a JPEG file before its first scan from r, reading no further than its SOS marker.
+func readHeader(r io.Reader) ([]segment, error) {
+	var buf [4]byte
+	if _, err := io.ReadFull(r, buf[:2]); err != nil {
+		return nil, err
+	}
+
+	if buf[0] != 0xFF || buf[1] != markerSOI {
+		return nil, errors.New("not a jpeg file: missing SOI marker")
+	}
+
+	var segments []segment
+
+	for {
+		if _, err := io.ReadFull(r, buf[:2]); err != nil {
+			return nil, err
+		}
+
+		// Markers may be preceded by any number of fill bytes.
+		for buf[0] == 0xFF && buf[1] == 0xFF {
+			if _, err := io.ReadFull(r, buf[1:2]); err != nil {
+				return nil, err
+			}
+		}
+
+		if buf[0] != 0xFF {
+			return nil, errors.New("invalid jpeg marker")
+		}
+
+		marker := buf[1]
+
+		if marker == markerSOS {
+			return segments, nil
+		}
+
+		if marker == markerEOI {
+			return nil, errors.New("invalid jpeg: no scan found")
+		}
+
+		if _, err := io.ReadFull(r, buf[2:4]); err != nil {
+			return nil, err
+		}
+
+		length := int(binary.BigEndian.Uint16(buf[2:]))
+		if length < 2 {
+			return nil, fmt.Errorf("invalid length of jpeg marker 0x%X", marker)
+		}
+
+		data := make([]byte, length-2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+
+		segments = append(segments, segment{marker: marker, data: data})
+	}
+}
+
+// joinHeader assembles a JPEG file from segments and the data following them, as returned by splitHeader.
+func joinHeader(segments []segment, rest []byte) []byte {
+	size := 2 + len(rest)
+	for _, s := range segments {
+		size += 4 + len(s.data)
+	}
+
+	out := make([]byte, 0, size)
+	out = append(out, 0xFF, markerSOI)
+
+	for _, s := range segments {
+		out = append(out, 0xFF, s.marker)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(s.data)+2))
+		out = append(out, s.data...)
+	}
+
+	return append(out, rest...)
+}
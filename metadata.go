@@ -0,0 +1,154 @@
+package mozjpegbin
+
+import (
+	"fmt"
+	"slices"
+)
+
+// MetadataPolicy selects the metadata of a JPEG source kept in the output.
+// The zero value drops everything, like JpegTran.CopyNone.
+type MetadataPolicy struct {
+	// KeepICC keeps the ICC color profile.
+	KeepICC bool
+	// KeepXMP keeps XMP packets.
+	KeepXMP bool
+	// KeepPhotoshop keeps Photoshop APP13 segments, which hold IPTC data.
+	KeepPhotoshop bool
+	// KeepComments keeps COM segments.
+	KeepComments bool
+	// KeepExif keeps EXIF data, filtered by the fields below.
+	KeepExif bool
+	// ExifTags, if not empty, lists the tags of IFD0 and of the Exif IFD kept, e.g. ExifTagCopyright.
+	// Other tags are dropped. If empty, all tags are kept.
+	ExifTags []uint16
+	// KeepGPS keeps GPS tags.
+	KeepGPS bool
+	// KeepMakerNote keeps the maker note. Maker notes holding offsets may not be readable after their move.
+	KeepMakerNote bool
+	// KeepThumbnail keeps the embedded thumbnail.
+	KeepThumbnail bool
+}
+
+// RightsMetadataPolicy keeps the ICC profile and the Artist, Copyright and Orientation EXIF tags only.
+var RightsMetadataPolicy = MetadataPolicy{
+	KeepICC:  true,
+	KeepExif: true,
+	ExifTags: []uint16{ExifTagArtist, ExifTagCopyright, ExifTagOrientation},
+}
+
+// copyPolicy copies policy, so that changes made by the caller later have no effect.
+func copyPolicy(policy *MetadataPolicy) *MetadataPolicy {
+	if policy == nil {
+		return nil
+	}
+
+	p := *policy
+	p.ExifTags = slices.Clone(policy.ExifTags)
+	return &p
+}
+
+// filter returns the source segments kept by p, with EXIF data rewritten to keep only the selected tags.
+func (p *MetadataPolicy) filter(segments []segment) ([]segment, error) {
+	var kept []segment
+
+	for _, s := range segments {
+		switch {
+		case s.marker == markerCOM && p.KeepComments,
+			s.marker == markerAPP2 && s.hasPrefix("ICC_PROFILE\x00") && p.KeepICC,
+			s.marker == markerAPP1 && s.hasPrefix("http://ns.adobe.com/xap/1.0/") && p.KeepXMP,
+			s.marker == markerAPP1 && s.hasPrefix("http://ns.adobe.com/xmp/extension/") && p.KeepXMP,
+			s.marker == markerAPP13 && p.KeepPhotoshop:
+			kept = append(kept, s)
+		case s.marker == markerAPP1 && s.hasPrefix(exifPrefix) && p.KeepExif:
+			exif, err := parseExif(s.data)
+			if err != nil {
+				return nil, err
+			}
+
+			p.filterExif(exif)
+			if exif.empty() {
+				continue
+			}
+
+			encoded := exif.encode()
+			if len(encoded)+2 > 0xFFFF {
+				return nil, fmt.Errorf("kept EXIF data of %d bytes doesn't fit in an APP1 segment", len(encoded))
+			}
+
+			kept = append(kept, segment{marker: markerAPP1, data: encoded})
+		}
+	}
+
+	return kept, nil
+}
+
+func (p *MetadataPolicy) filterExif(e *exifData) {
+	keep := func(entry tiffEntry) bool {
+		if entry.tag == ExifTagMakerNote && !p.KeepMakerNote {
+			return false
+		}
+
+		return len(p.ExifTags) == 0 || slices.Contains(p.ExifTags, entry.tag)
+	}
+
+	e.ifd0 = slices.DeleteFunc(e.ifd0, func(entry tiffEntry) bool { return !keep(entry) })
+	e.exif = slices.DeleteFunc(e.exif, func(entry tiffEntry) bool { return !keep(entry) })
+
+	if len(e.exif) == 0 || len(p.ExifTags) > 0 && !slices.Contains(p.ExifTags, exifTagInteropIFD) {
+		e.interop = nil
+	}
+
+	if !p.KeepGPS {
+		e.gps = nil
+	}
+
+	if !p.KeepThumbnail {
+		e.ifd1 = nil
+		e.thumbnail = nil
+	}
+}
+
+// applyMetadata replaces the metadata of the encoded output with the metadata of source kept by policy.
+// The JFIF and Adobe segments of output are left alone, as they describe how output is encoded.
+// Kept segments are placed after the JFIF segment, in their source order.
+func applyMetadata(policy *MetadataPolicy, source, output []byte) ([]byte, error) {
+	var kept []segment
+
+	if isJPEG(source) {
+		segments, _, err := splitHeader(source)
+		if err != nil {
+			return nil, err
+		}
+
+		if kept, err = policy.filter(segments); err != nil {
+			return nil, err
+		}
+	}
+
+	segments, rest, err := splitHeader(output)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []segment
+	inserted := false
+
+	for _, s := range segments {
+		if s.marker == markerCOM || s.isAPP() && !(s.marker == markerAPP0 && s.hasPrefix("JFIF\x00")) && !(s.marker == markerAPP14 && s.hasPrefix("Adobe")) {
+			continue
+		}
+
+		if !inserted && !(s.marker == markerAPP0 && s.hasPrefix("JFIF\x00")) {
+			result = append(result, kept...)
+			inserted = true
+		}
+
+		result = append(result, s)
+	}
+
+	if !inserted {
+		result = append(result, kept...)
+	}
+
+	return joinHeader(result, rest), nil
+}
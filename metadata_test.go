@@ -0,0 +1,201 @@
+package mozjpegbin_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/stretchr/testify/assert"
+)
+
+type testEntry struct {
+	tag, typ uint16
+	value    []byte
+}
+
+func testIFDSize(entries []testEntry) uint32 {
+	size := uint32(2 + 12*len(entries) + 4)
+	for _, e := range entries {
+		if len(e.value) > 4 {
+			size += uint32(len(e.value)+1) &^ 1
+		}
+	}
+	return size
+}
+
+func testIFD(entries []testEntry, offset, next uint32) []byte {
+	out := binary.BigEndian.AppendUint16(nil, uint16(len(entries)))
+	valueOffset := offset + uint32(2+12*len(entries)+4)
+	var values []byte
+
+	for _, e := range entries {
+		out = binary.BigEndian.AppendUint16(out, e.tag)
+		out = binary.BigEndian.AppendUint16(out, e.typ)
+		count := len(e.value)
+		if e.typ == 3 {
+			count /= 2
+		}
+		if e.typ == 4 {
+			count /= 4
+		}
+		out = binary.BigEndian.AppendUint32(out, uint32(count))
+
+		if len(e.value) <= 4 {
+			var inline [4]byte
+			copy(inline[:], e.value)
+			out = append(out, inline[:]...)
+			continue
+		}
+
+		out = binary.BigEndian.AppendUint32(out, valueOffset+uint32(len(values)))
+		values = append(values, e.value...)
+		if len(values)%2 == 1 {
+			values = append(values, 0)
+		}
+	}
+
+	out = binary.BigEndian.AppendUint32(out, next)
+	return append(out, values...)
+}
+
+func long(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+// testExif builds an EXIF segment payload with rights, camera, GPS, maker note and thumbnail data.
+func testExif() []byte {
+	exifIFD := []testEntry{
+		{0x927C, 7, []byte("MAKERNOTE-DATA")},
+		{0xA002, 4, long(1203)},
+		{0xA003, 4, long(901)},
+	}
+	gpsIFD := []testEntry{{0x001B, 7, []byte("GPS-SECRET")}}
+	ifd0 := []testEntry{
+		{0x010F, 2, []byte("SecretCam\x00")},
+		{0x0112, 3, []byte{0, 6}},
+		{0x013B, 2, []byte("Jane Doe\x00")},
+		{0x8298, 2, []byte("(c) Jane\x00")},
+		{0x8769, 4, nil},
+		{0x8825, 4, nil},
+	}
+	thumbnail := []byte("THUMBNAIL-DATA")
+
+	exifOffset := 8 + testIFDSize(ifd0)
+	gpsOffset := exifOffset + testIFDSize(exifIFD)
+	ifd1Offset := gpsOffset + testIFDSize(gpsIFD)
+	ifd1 := []testEntry{{0x0201, 4, nil}, {0x0202, 4, long(uint32(len(thumbnail)))}}
+	thumbOffset := ifd1Offset + testIFDSize(ifd1)
+
+	ifd0[4].value = long(exifOffset)
+	ifd0[5].value = long(gpsOffset)
+	ifd1[0].value = long(thumbOffset)
+
+	out := []byte("Exif\x00\x00MM\x00\x2A\x00\x00\x00\x08")
+	out = append(out, testIFD(ifd0, 8, ifd1Offset)...)
+	out = append(out, testIFD(exifIFD, exifOffset, 0)...)
+	out = append(out, testIFD(gpsIFD, gpsOffset, 0)...)
+	out = append(out, testIFD(ifd1, ifd1Offset, 0)...)
+	return append(out, thumbnail...)
+}
+
+func testSegment(marker byte, payload []byte) []byte {
+	out := []byte{0xFF, marker}
+	out = binary.BigEndian.AppendUint16(out, uint16(len(payload)+2))
+	return append(out, payload...)
+}
+
+// jpegWithMetadata returns a JPEG file with EXIF, ICC, Photoshop and comment segments.
+func jpegWithMetadata(t *testing.T) []byte {
+	source := encodeStdJpeg(t, 80)
+
+	data := []byte{0xFF, 0xD8}
+	data = append(data, testSegment(0xE1, testExif())...)
+	data = append(data, testSegment(0xE2, []byte("ICC_PROFILE\x00\x01\x01ICC-DATA"))...)
+	data = append(data, testSegment(0xED, []byte("Photoshop 3.0\x00IPTC-DATA"))...)
+	data = append(data, testSegment(0xFE, []byte("COMMENT-DATA"))...)
+	return append(data, source[2:]...)
+}
+
+func assertRightsOnly(t *testing.T, data []byte) {
+	info, err := mozjpegbin.Inspect(bytes.NewReader(data))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var identifiers []string
+	for _, m := range info.Markers {
+		if m.Identifier != "JFIF" {
+			identifiers = append(identifiers, m.Identifier)
+		}
+	}
+
+	assert.Equal(t, []string{"Exif", "ICC_PROFILE"}, identifiers)
+
+	for _, kept := range []string{"Jane Doe", "(c) Jane", "ICC-DATA"} {
+		assert.True(t, bytes.Contains(data, []byte(kept)), kept)
+	}
+
+	orientation := []byte{0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, 6}
+	assert.True(t, bytes.Contains(data, orientation), "orientation")
+
+	for _, dropped := range []string{"SecretCam", "GPS-SECRET", "MAKERNOTE-DATA", "THUMBNAIL-DATA", "IPTC-DATA", "COMMENT-DATA"} {
+		assert.False(t, bytes.Contains(data, []byte(dropped)), dropped)
+	}
+}
+
+func TestCJpegMetadata(t *testing.T) {
+	c, err := mozjpegbin.NewCJpeg()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var out bytes.Buffer
+	err = c.Input(bytes.NewReader(jpegWithMetadata(t))).Output(&out).Metadata(&mozjpegbin.RightsMetadataPolicy).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assertRightsOnly(t, out.Bytes())
+	assert.Equal(t, out.Len(), c.Result().Size)
+}
+
+func TestJpegTranMetadata(t *testing.T) {
+	c, err := mozjpegbin.NewJpegTran()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var out bytes.Buffer
+	err = c.Input(bytes.NewReader(jpegWithMetadata(t))).Output(&out).Metadata(&mozjpegbin.RightsMetadataPolicy).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assertRightsOnly(t, out.Bytes())
+}
+
+func TestJpegTranMetadataKeepAllExif(t *testing.T) {
+	c, err := mozjpegbin.NewJpegTran()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var out bytes.Buffer
+	policy := &mozjpegbin.MetadataPolicy{KeepExif: true, KeepGPS: true, KeepThumbnail: true, KeepComments: true}
+	err = c.Input(bytes.NewReader(jpegWithMetadata(t))).Output(&out).Metadata(policy).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	for _, kept := range []string{"SecretCam", "Jane Doe", "GPS-SECRET", "THUMBNAIL-DATA", "COMMENT-DATA"} {
+		assert.True(t, bytes.Contains(out.Bytes(), []byte(kept)), kept)
+	}
+
+	for _, dropped := range []string{"MAKERNOTE-DATA", "ICC-DATA", "IPTC-DATA"} {
+		assert.False(t, bytes.Contains(out.Bytes(), []byte(dropped)), dropped)
+	}
+
+	_, err = mozjpegbin.Decode(&out)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,110 @@
+// Package metrics measures the quality of encoded images against their source with PSNR, SSIM and MS-SSIM.
+//
+// Channels are compared in the YCbCr color space JPEG works in, at full resolution.
+// JPEG data is decoded with mozjpegbin.Decode, so the numbers are those of mozjpeg's own decoder.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/Munchpass/go-mozjpegbin/internal/similarity"
+)
+
+// Channel holds the metrics of a single channel.
+type Channel struct {
+	// Name is "Y", "Cb" or "Cr".
+	Name string
+	// PSNR is the peak signal-to-noise ratio in dB, +Inf for identical channels.
+	PSNR   float64
+	SSIM   float64
+	MSSSIM float64
+}
+
+// Result holds the metrics of every channel and of the whole image.
+type Result struct {
+	// Channels are Y, Cb and Cr, or only Y when both images are grayscale.
+	Channels []Channel
+	// PSNR is computed from the mean squared error over all channels.
+	PSNR float64
+	// SSIM is the mean of the channel SSIM values.
+	SSIM float64
+	// MSSSIM is the mean of the channel MS-SSIM values.
+	MSSSIM float64
+}
+
+// Compare compares img with ref. Both images must have the same size, at least 11x11 pixels.
+func Compare(ref, img image.Image) (*Result, error) {
+	if ref.Bounds().Dx() != img.Bounds().Dx() || ref.Bounds().Dy() != img.Bounds().Dy() {
+		return nil, fmt.Errorf("image sizes differ: %v and %v", ref.Bounds().Size(), img.Bounds().Size())
+	}
+
+	names := []string{"Y"}
+	a := []*similarity.Plane{similarity.Luma(ref)}
+	b := []*similarity.Plane{similarity.Luma(img)}
+
+	if !isGray(ref) || !isGray(img) {
+		names = append(names, "Cb", "Cr")
+		cbA, crA := similarity.Chroma(ref)
+		cbB, crB := similarity.Chroma(img)
+		a = append(a, cbA, crA)
+		b = append(b, cbB, crB)
+	}
+
+	result := &Result{}
+	var mse float64
+
+	for i, name := range names {
+		channelMSE, err := similarity.MSE(a[i], b[i])
+		if err != nil {
+			return nil, err
+		}
+
+		ssim, err := similarity.SSIM(a[i], b[i])
+		if err != nil {
+			return nil, err
+		}
+
+		msssim, err := similarity.MSSSIM(a[i], b[i])
+		if err != nil {
+			return nil, err
+		}
+
+		mse += channelMSE
+		result.SSIM += ssim
+		result.MSSSIM += msssim
+		result.Channels = append(result.Channels, Channel{Name: name, PSNR: psnr(channelMSE), SSIM: ssim, MSSSIM: msssim})
+	}
+
+	n := float64(len(names))
+	result.PSNR = psnr(mse / n)
+	result.SSIM /= n
+	result.MSSSIM /= n
+	return result, nil
+}
+
+// CompareJPEG decodes data with mozjpeg and compares it with ref.
+func CompareJPEG(ref image.Image, data []byte) (*Result, error) {
+	img, err := mozjpegbin.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode jpeg: %v", err)
+	}
+
+	return Compare(ref, img)
+}
+
+func psnr(mse float64) float64 {
+	if mse == 0 {
+		return math.Inf(1)
+	}
+
+	return 10 * math.Log10(255*255/mse)
+}
+
+func isGray(img image.Image) bool {
+	_, ok := img.(*image.Gray)
+	return ok
+}
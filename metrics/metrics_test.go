@@ -0,0 +1,69 @@
+package metrics_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/Munchpass/go-mozjpegbin/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 128, 96))
+	for y := 0; y < 96; y++ {
+		for x := 0; x < 128; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 2), G: uint8((x * y) % 256), B: uint8(y * 2), A: 255})
+		}
+	}
+	return img
+}
+
+func TestCompareIdentical(t *testing.T) {
+	img := testImage()
+
+	result, err := metrics.Compare(img, img)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Len(t, result.Channels, 3)
+	assert.True(t, math.IsInf(result.PSNR, 1))
+	assert.InDelta(t, 1, result.SSIM, 1e-9)
+	assert.InDelta(t, 1, result.MSSSIM, 1e-9)
+}
+
+func TestCompareJPEG(t *testing.T) {
+	img := testImage()
+	var results []*metrics.Result
+
+	for _, quality := range []uint{30, 90} {
+		var out bytes.Buffer
+		err := mozjpegbin.Encode(&out, img, &mozjpegbin.Options{Quality: quality})
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+
+		result, err := metrics.CompareJPEG(img, out.Bytes())
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+
+		assert.Equal(t, "Y", result.Channels[0].Name)
+		assert.Less(t, result.SSIM, 1.0)
+		results = append(results, result)
+	}
+
+	assert.Greater(t, results[1].PSNR, results[0].PSNR)
+	assert.Greater(t, results[1].SSIM, results[0].SSIM)
+	assert.Greater(t, results[1].MSSSIM, results[0].MSSSIM)
+	assert.Greater(t, results[1].Channels[0].SSIM, results[0].Channels[0].SSIM)
+}
+
+func TestCompareSizeMismatch(t *testing.T) {
+	_, err := metrics.Compare(testImage(), image.NewGray(image.Rect(0, 0, 20, 20)))
+	assert.NotNil(t, err)
+}
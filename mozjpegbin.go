@@ -1,15 +1,16 @@
 package mozjpegbin
 
 import (
-	"bytes"
+	"context"
 	"embed"
 	"fmt"
 	"image"
-	"image/jpeg"
 	"io"
+	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/Munchpass/go-mozjpegbin/embedbinwrapper"
 )
@@ -17,54 +18,115 @@ import (
 //go:embed bin/*
 var binariesFs embed.FS
 
+// inProcessCodec is implemented by backends that decode without running any tool.
+type inProcessCodec interface {
+	decode(data []byte) (image.Image, error)
+}
+
+// inProcess is set when the package is built with the mozjpeg_cgo tag.
+var inProcess inProcessCodec
+
+// binaries caches embedded binaries by path, as reading from binariesFs copies them every time.
+var binaries sync.Map
+
+func readBinary(path string) ([]byte, error) {
+	if bin, ok := binaries.Load(path); ok {
+		return bin.([]byte), nil
+	}
+
+	bin, err := binariesFs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	binaries.Store(path, bin)
+	return bin, nil
+}
+
 func createBinWrapper(binaryName string) (*embedbinwrapper.EmbedBinWrapper, error) {
-	b := embedbinwrapper.NewExecutableBinWrapper()
+	binary, platform, err := embeddedBinary(binaryName)
+	if err != nil {
+		return nil, err
+	}
+
+	return embedbinwrapper.NewExecutableBinWrapper().Src(embedbinwrapper.NewSrc().Bin(binary).Os(platform)), nil
+}
+
+// embeddedBinary returns the embedded binary of binaryName for the current OS, and the OS name used by embedbinwrapper.
+func embeddedBinary(binaryName string) ([]byte, string, error) {
+	var binPath, platform string
 	switch runtime.GOOS {
 	case "windows":
-		binPath := fmt.Sprintf("bin/windows/%s", binaryName)
+		binPath = fmt.Sprintf("bin/windows/%s", binaryName)
 		ext := strings.ToLower(filepath.Ext(binPath))
 		if ext != ".exe" {
 			binPath += ".exe"
 		}
-
-		binary, err := binariesFs.ReadFile(binPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read embed binary: %s", err)
-		}
-		return b.Src(embedbinwrapper.NewSrc().Bin(binary).Os("win32")), nil
+		platform = "win32"
 	case "linux":
-		binary, err := binariesFs.ReadFile(fmt.Sprintf("bin/linux/%s", binaryName))
-		if err != nil {
-			return nil, fmt.Errorf("failed to read embed binary: %s", err)
-		}
-		return b.Src(embedbinwrapper.NewSrc().Bin(binary).Os("linux")), nil
-
+		binPath = fmt.Sprintf("bin/linux/%s", binaryName)
+		platform = "linux"
 	case "darwin":
-		binary, err := binariesFs.ReadFile(fmt.Sprintf("bin/darwin/%s", binaryName))
-		if err != nil {
-			return nil, fmt.Errorf("failed to read embed binary: %s", err)
-		}
-		return b.Src(embedbinwrapper.NewSrc().Bin(binary).Os("darwin")), nil
+		binPath = fmt.Sprintf("bin/darwin/%s", binaryName)
+		platform = "darwin"
 	default:
-		return nil, fmt.Errorf("unsupported OS %s", runtime.GOOS)
+		return nil, "", fmt.Errorf("unsupported OS %s", runtime.GOOS)
+	}
+
+	binary, err := readBinary(binPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read embed binary: %s", err)
 	}
+
+	return binary, platform, nil
 }
 
-func createReaderFromImage(img image.Image) (io.Reader, error) {
-	var buffer bytes.Buffer
-	err := jpeg.Encode(&buffer, img, &jpeg.Options{Quality: 100})
-	return &buffer, err
+// defaultQuality is the quality cjpeg uses when none is given.
+const defaultQuality = 75
+
+// countingWriter counts bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
+// writeTo writes data to w, or to the file named outfile if w is nil.
+func writeTo(data []byte, w io.Writer, outfile string) error {
+	if w != nil {
+		_, err := w.Write(data)
+		return err
+	}
+
+	return os.WriteFile(outfile, data, 0666)
+}
+
+// defaultRunnerFor returns DefaultRunner, checking that it's able to run tool when it's the EmbeddedRunner.
+func defaultRunnerFor(tool string) (Runner, error) {
+	runner := DefaultRunner()
+
+	if embedded, ok := runner.(*EmbeddedRunner); ok {
+		if err := embedded.Available(tool); err != nil {
+			return nil, fmt.Errorf("failed to create bin wrapper: %v", err)
+		}
+	}
+
+	return runner, nil
 }
 
-func version(b *embedbinwrapper.EmbedBinWrapper) (string, error) {
-	b.Reset()
-	err := b.Run("-version")
+func version(r Runner, tool string) (string, error) {
+	res, err := runTool(context.Background(), r, &Invocation{Tool: tool, Args: []string{"-version"}})
 
 	if err != nil {
 		return "", err
 	}
 
-	v := string(b.StdErr())
+	v := string(res.Stderr)
 	v = strings.Replace(v, "\n", "", -1)
 	v = strings.Replace(v, "\r", "", -1)
 	return v, nil
@@ -0,0 +1,156 @@
+package mozjpegbin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/png" // PNG sources are decoded to be compared with the output
+
+	"github.com/Munchpass/go-mozjpegbin/internal/similarity"
+)
+
+// Metric is a perceptual metric comparing the decoded output with the source.
+type Metric int
+
+const (
+	// MetricSSIM is the structural similarity index of the luma channel.
+	MetricSSIM Metric = iota
+	// MetricMSSSIM is the multi-scale structural similarity index of the luma channel.
+	MetricMSSSIM
+)
+
+// String returns the name of the metric.
+func (m Metric) String() string {
+	switch m {
+	case MetricSSIM:
+		return "SSIM"
+	case MetricMSSSIM:
+		return "MS-SSIM"
+	default:
+		return fmt.Sprintf("Metric(%d)", int(m))
+	}
+}
+
+// ErrTargetQualityUnreachable is returned when even the highest quality doesn't score enough.
+var ErrTargetQualityUnreachable = errors.New("target quality is unreachable")
+
+type qualityTarget struct {
+	metric Metric
+	min    float64
+}
+
+// TargetQuality makes Run pick the lowest quality whose output scores at least min with metric against the source,
+// e.g. TargetQuality(MetricSSIM, 0.98).
+// Every candidate is decoded with djpeg, so the score is the one of the image users will actually see.
+// The quality set with Quality is the highest one tried, 100 if it wasn't set.
+// Run bisects the quality range, encoding at most 8 times. Result reports the chosen quality and its score.
+// If even the highest quality scores less than min, Run returns an error wrapping ErrTargetQualityUnreachable.
+//
+// The source must be an image set with InputImage, or a JPEG, PNG, PGM or PPM file or stream.
+// TargetSize called before will be ignored.
+func (c *CJpeg) TargetQuality(metric Metric, min float64) *CJpeg {
+	c.targetSize = 0
+	c.target = &qualityTarget{metric: metric, min: min}
+	return c
+}
+
+func (c *CJpeg) runTargetQuality(ctx context.Context, in *cjpegInput) ([]byte, *EncodeResult, error) {
+	ref, err := c.reference(in)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode source to compute %v: %v", c.target.metric, err)
+	}
+
+	refPlane := similarity.Luma(ref)
+
+	data, quality, score, trials, err := searchLowestQuality(0, c.maxQuality(), c.target.min, func(quality int) ([]byte, float64, error) {
+		data, err := c.encodeBytes(ctx, quality, in)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		img, err := decodeWithRunner(ctx, c.Runner, bytes.NewReader(data))
+		if err != nil {
+			return nil, 0, err
+		}
+
+		score, err := c.target.metric.compare(refPlane, similarity.Luma(img))
+		return data, score, err
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data, &EncodeResult{Quality: quality, Size: len(data), Trials: trials, Score: score}, nil
+}
+
+func (m Metric) compare(a, b *similarity.Plane) (float64, error) {
+	switch m {
+	case MetricSSIM:
+		return similarity.SSIM(a, b)
+	case MetricMSSSIM:
+		return similarity.MSSSIM(a, b)
+	default:
+		return 0, fmt.Errorf("unknown metric %v", m)
+	}
+}
+
+// reference returns the source image to compare the output with.
+func (c *CJpeg) reference(in *cjpegInput) (image.Image, error) {
+	if in.ycbcr != nil {
+		return in.ycbcr, nil
+	}
+
+	if in.image != nil {
+		return in.image, nil
+	}
+
+	data, err := in.bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(data, []byte("P5")) || bytes.HasPrefix(data, []byte("P6")) {
+		return readPNM(bytes.NewReader(data))
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// searchLowestQuality finds the lowest quality between lo and hi whose output scores at least min.
+func searchLowestQuality(lo, hi int, min float64, score func(quality int) ([]byte, float64, error)) ([]byte, int, float64, int, error) {
+	best, bestScore, err := score(hi)
+	if err != nil {
+		return nil, 0, 0, 1, err
+	}
+
+	if bestScore < min {
+		return nil, 0, 0, 1, fmt.Errorf("%w: score is %.5f at quality %d, %.5f requested", ErrTargetQualityUnreachable, bestScore, hi, min)
+	}
+
+	trials := 1
+	bestQuality := hi
+
+	for hi--; lo <= hi && trials < maxTargetSizeTrials; {
+		mid := (lo + hi) / 2
+
+		data, value, err := score(mid)
+		trials++
+
+		if err != nil {
+			return nil, 0, 0, trials, err
+		}
+
+		if value >= min {
+			best, bestQuality, bestScore = data, mid, value
+			hi = mid - 1
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	return best, bestQuality, bestScore, trials, nil
+}
@@ -0,0 +1,43 @@
+package mozjpegbin_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCJpegTargetQuality(t *testing.T) {
+	for _, metric := range []mozjpegbin.Metric{mozjpegbin.MetricSSIM, mozjpegbin.MetricMSSSIM} {
+		t.Run(metric.String(), func(t *testing.T) {
+			c, err := mozjpegbin.NewCJpeg()
+			if !assert.Nil(t, err) {
+				t.FailNow()
+			}
+
+			var out bytes.Buffer
+			err = c.InputFile("source.jpg").Output(&out).TargetQuality(metric, 0.97).Run()
+			if !assert.Nil(t, err) {
+				t.FailNow()
+			}
+
+			result := c.Result()
+			assert.GreaterOrEqual(t, result.Score, 0.97)
+			assert.Less(t, result.Quality, 100)
+			assert.LessOrEqual(t, result.Trials, 8)
+			assert.Equal(t, out.Len(), result.Size)
+		})
+	}
+}
+
+func TestCJpegTargetQualityUnreachable(t *testing.T) {
+	c, err := mozjpegbin.NewCJpeg()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	err = c.InputFile("source.jpg").Output(&bytes.Buffer{}).Quality(10).TargetQuality(mozjpegbin.MetricSSIM, 0.999).Run()
+	assert.True(t, errors.Is(err, mozjpegbin.ErrTargetQualityUnreachable))
+}
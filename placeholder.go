@@ -0,0 +1,251 @@
+package mozjpegbin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// defaultPlaceholderSize is the longest side of placeholders when PlaceholderOptions.Size isn't set.
+const defaultPlaceholderSize = 24
+
+// Default quantization tables of placeholders: the tables of Annex K at quality 20.
+// Coarse AC coefficients are fine for an image meant to be shown blurred.
+var (
+	placeholderLuminanceQuant   = scaleQuant(&stdLuminanceQuant, 20)
+	placeholderChrominanceQuant = scaleQuant(&stdChrominanceQuant, 20)
+)
+
+// PlaceholderOptions configures Placeholder.
+type PlaceholderOptions struct {
+	// Size is the longest side of the placeholder in pixels, 24 if 0. Smaller images aren't enlarged.
+	Size int
+	// Blur is the standard deviation of a gaussian blur applied to the placeholder, in its pixels. 0 disables it.
+	Blur float64
+	// QuantTables replace the default quantization tables, in natural order: luma, then chroma.
+	QuantTables [][64]int
+	// Background is the color transparent images are composited over, white if nil.
+	Background color.Color
+	// StripHeader splits the output into Header and Body, so that the header can be shared by all placeholders.
+	StripHeader bool
+}
+
+// PlaceholderImage is a low quality image placeholder made by Placeholder.
+type PlaceholderImage struct {
+	Width  int
+	Height int
+	// Data is the JPEG file.
+	Data []byte
+	// DataURI is Data as a data:image/jpeg;base64 URI.
+	DataURI string
+	// Header is the beginning of Data up to the entropy coded data, with the dimensions set to zero.
+	// It's the same for all placeholders made with the same QuantTables, so that it can be shipped once,
+	// e.g. with frontend code, and only Body, Width and Height stored per image. JoinPlaceholder rebuilds Data.
+	// It's only set with StripHeader.
+	Header []byte
+	// Body is the rest of Data. It's only set with StripHeader.
+	Body []byte
+}
+
+// Placeholder makes a tiny, heavily quantized JPEG of img to show while the real image loads.
+// It's encoded by cjpeg with standard Huffman tables and fixed quantization tables, so that all placeholders
+// share the same header, and is always a 4:2:0 color JPEG, even for grayscale images.
+func Placeholder(img image.Image, opts *PlaceholderOptions) (*PlaceholderImage, error) {
+	if opts == nil {
+		opts = &PlaceholderOptions{}
+	}
+
+	size := opts.Size
+	if size <= 0 {
+		size = defaultPlaceholderSize
+	}
+
+	small := toRGBA(resizeImage(img, opts.Background, &Resize{Width: size, Height: size}))
+	if opts.Blur > 0 {
+		small = blur(small, opts.Blur)
+	}
+
+	tables := opts.QuantTables
+	if len(tables) == 0 {
+		tables = [][64]int{placeholderLuminanceQuant, placeholderChrominanceQuant}
+	}
+
+	var pnm bytes.Buffer
+	if err := writePNM(&pnm, small); err != nil {
+		return nil, err
+	}
+
+	c, err := NewCJpeg()
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	err = c.Revert(true).
+		Baseline(true).
+		Subsample(image.YCbCrSubsampleRatio420).
+		QuantTables(tables...).
+		Input(&pnm).
+		Output(&out).
+		Run()
+	if err != nil {
+		return nil, err
+	}
+
+	data := out.Bytes()
+	p := &PlaceholderImage{
+		Width:   small.Bounds().Dx(),
+		Height:  small.Bounds().Dy(),
+		Data:    data,
+		DataURI: "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(data),
+	}
+
+	if opts.StripHeader {
+		if p.Header, p.Body, err = splitPlaceholder(data); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// JoinPlaceholder rebuilds the JPEG file of a placeholder from the shared header, its body and its dimensions.
+func JoinPlaceholder(header, body []byte, width, height int) ([]byte, error) {
+	if width <= 0 || height <= 0 || width > 0xFFFF || height > 0xFFFF {
+		return nil, errors.New("invalid placeholder dimensions")
+	}
+
+	data := append(append(make([]byte, 0, len(header)+len(body)), header...), body...)
+	sof, err := findSOF(data)
+	if err != nil {
+		return nil, err
+	}
+
+	binary.BigEndian.PutUint16(data[sof+1:], uint16(height))
+	binary.BigEndian.PutUint16(data[sof+3:], uint16(width))
+	return data, nil
+}
+
+// splitPlaceholder splits data after the SOS segment, and zeroes the dimensions in the header.
+func splitPlaceholder(data []byte) ([]byte, []byte, error) {
+	_, rest, err := splitHeader(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(rest) < 4 {
+		return nil, nil, errors.New("invalid jpeg: truncated SOS segment")
+	}
+
+	end := len(data) - len(rest) + 2 + int(binary.BigEndian.Uint16(rest[2:]))
+	if end > len(data) {
+		return nil, nil, errors.New("invalid jpeg: truncated SOS segment")
+	}
+
+	header := bytes.Clone(data[:end])
+	sof, err := findSOF(header)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clear(header[sof+1 : sof+5])
+	return header, data[end:], nil
+}
+
+// findSOF returns the offset of the payload of the SOF segment in data.
+func findSOF(data []byte) (int, error) {
+	segments, _, err := splitHeader(data)
+	if err != nil {
+		return 0, err
+	}
+
+	pos := 2
+	for _, s := range segments {
+		if isSOF(s.marker) {
+			if len(s.data) < 5 {
+				return 0, errors.New("invalid SOF segment")
+			}
+
+			return pos + 4, nil
+		}
+
+		pos += 4 + len(s.data)
+	}
+
+	return 0, errors.New("invalid jpeg: no SOF segment")
+}
+
+// toRGBA returns img as an *image.RGBA.
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba
+}
+
+// blur applies a gaussian blur of standard deviation sigma to an opaque image, in linear light.
+// Pixels beyond the edges repeat the edge pixels.
+func blur(img *image.RGBA, sigma float64) *image.RGBA {
+	radius := int(math.Ceil(3 * sigma))
+	kernel := make([]float64, 2*radius+1)
+	sum := 0.0
+	for i := range kernel {
+		d := float64(i - radius)
+		kernel[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	linear := make([]float64, w*h*3)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := img.PixOffset(b.Min.X+x, b.Min.Y+y)
+			for j := 0; j < 3; j++ {
+				linear[(y*w+x)*3+j] = srgbToLinear[img.Pix[i+j]]
+			}
+		}
+	}
+
+	pass := func(src []float64, dx, dy int) []float64 {
+		dst := make([]float64, len(src))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				for k, weight := range kernel {
+					sx := min(max(x+(k-radius)*dx, 0), w-1)
+					sy := min(max(y+(k-radius)*dy, 0), h-1)
+					for j := 0; j < 3; j++ {
+						dst[(y*w+x)*3+j] += weight * src[(sy*w+sx)*3+j]
+					}
+				}
+			}
+		}
+
+		return dst
+	}
+
+	linear = pass(pass(linear, 1, 0), 0, 1)
+
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < w*h; i++ {
+		for j := 0; j < 3; j++ {
+			out.Pix[i*4+j] = linearToSRGB[int(min(max(linear[i*3+j], 0), 1)*float64(len(linearToSRGB)-1)+0.5)]
+		}
+
+		out.Pix[i*4+3] = 0xFF
+	}
+
+	return out
+}
@@ -0,0 +1,113 @@
+package mozjpegbin_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlaceholder(t *testing.T) {
+	f, err := os.Open("source.jpg")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer f.Close()
+
+	src, err := jpeg.Decode(f)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	p, err := mozjpegbin.Placeholder(src, &mozjpegbin.PlaceholderOptions{Size: 32, Blur: 1})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, 32, p.Width)
+	assert.Equal(t, 24, p.Height)
+	assert.Less(t, len(p.Data), 1024)
+	assert.Nil(t, p.Header)
+
+	decoded, err := jpeg.Decode(bytes.NewReader(p.Data))
+	if assert.Nil(t, err) {
+		assert.Equal(t, image.Rect(0, 0, 32, 24), decoded.Bounds())
+	}
+
+	if assert.True(t, strings.HasPrefix(p.DataURI, "data:image/jpeg;base64,")) {
+		data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(p.DataURI, "data:image/jpeg;base64,"))
+		if assert.Nil(t, err) {
+			assert.Equal(t, p.Data, data)
+		}
+	}
+}
+
+func TestPlaceholderSharedHeader(t *testing.T) {
+	wide := image.NewRGBA(image.Rect(0, 0, 300, 100))
+	for i := range wide.Pix {
+		wide.Pix[i] = byte(i * 7)
+	}
+
+	gray := image.NewGray(image.Rect(0, 0, 50, 80))
+	for i := range gray.Pix {
+		gray.Pix[i] = byte(i)
+	}
+	gray.Set(0, 0, color.Gray{Y: 255})
+
+	opts := &mozjpegbin.PlaceholderOptions{Size: 16, StripHeader: true}
+
+	a, err := mozjpegbin.Placeholder(wide, opts)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	b, err := mozjpegbin.Placeholder(gray, opts)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, a.Header, b.Header)
+	assert.Equal(t, image.Pt(16, 5), image.Pt(a.Width, a.Height))
+	assert.Equal(t, image.Pt(10, 16), image.Pt(b.Width, b.Height))
+
+	for _, p := range []*mozjpegbin.PlaceholderImage{a, b} {
+		data, err := mozjpegbin.JoinPlaceholder(p.Header, p.Body, p.Width, p.Height)
+		if assert.Nil(t, err) {
+			assert.Equal(t, p.Data, data)
+		}
+	}
+}
+
+func TestCJpegQuantTables(t *testing.T) {
+	var luma, chroma [64]int
+	for i := range luma {
+		luma[i] = 10 + i
+		chroma[i] = 40 + i
+	}
+
+	c, err := mozjpegbin.NewCJpeg()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var out bytes.Buffer
+	err = c.Revert(true).QuantTables(luma, chroma).InputImage(testImage()).Output(&out).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	info, err := mozjpegbin.Inspect(&out)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.True(t, info.Baseline)
+	assert.Equal(t, map[int][64]int{0: luma, 1: chroma}, info.QuantTables)
+}
@@ -0,0 +1,267 @@
+package mozjpegbin
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+)
+
+// readPNM reads a PGM (P2, P5) or PPM (P3, P6) image, like the ones produced by djpeg.
+// Samples are scaled to 8 bits, or to 16 bits when the max value is above 255.
+func readPNM(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := readPNMToken(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pnm header: %v", err)
+	}
+
+	if magic != "P2" && magic != "P3" && magic != "P5" && magic != "P6" {
+		return nil, fmt.Errorf("unsupported pnm format %q", magic)
+	}
+
+	var header [3]int
+	for i := range header {
+		token, err := readPNMToken(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pnm header: %v", err)
+		}
+
+		if _, err := fmt.Sscanf(token, "%d", &header[i]); err != nil {
+			return nil, fmt.Errorf("invalid pnm header value %q", token)
+		}
+	}
+
+	width, height, maxVal := header[0], header[1], header[2]
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("invalid pnm dimensions")
+	}
+
+	if maxVal <= 0 || maxVal > 65535 {
+		return nil, fmt.Errorf("invalid pnm max value %d", maxVal)
+	}
+
+	rect := image.Rect(0, 0, width, height)
+
+	if maxVal != 255 || magic == "P2" || magic == "P3" {
+		return readPNMSamples(br, magic, rect, maxVal)
+	}
+
+	if magic == "P5" {
+		img := image.NewGray(rect)
+		_, err = io.ReadFull(br, img.Pix)
+		return img, err
+	}
+
+	img := image.NewRGBA(rect)
+	row := make([]byte, width*3)
+	for y := 0; y < height; y++ {
+		if _, err := io.ReadFull(br, row); err != nil {
+			return nil, err
+		}
+
+		dst := img.Pix[y*img.Stride:]
+		for x := 0; x < width; x++ {
+			dst[x*4] = row[x*3]
+			dst[x*4+1] = row[x*3+1]
+			dst[x*4+2] = row[x*3+2]
+			dst[x*4+3] = 0xff
+		}
+	}
+
+	return img, nil
+}
+
+// readPNMSamples reads the raster of an ASCII PNM, or of a binary one whose max value isn't 255.
+func readPNMSamples(br *bufio.Reader, magic string, rect image.Rectangle, maxVal int) (image.Image, error) {
+	next := func() (int, error) {
+		switch {
+		case magic == "P2" || magic == "P3":
+			token, err := readPNMToken(br)
+			if err != nil {
+				return 0, err
+			}
+
+			var v int
+			if _, err := fmt.Sscanf(token, "%d", &v); err != nil {
+				return 0, fmt.Errorf("invalid pnm sample %q", token)
+			}
+
+			return v, nil
+		case maxVal < 256:
+			b, err := br.ReadByte()
+			return int(b), err
+		default:
+			var b [2]byte
+			_, err := io.ReadFull(br, b[:])
+			return int(b[0])<<8 | int(b[1]), err
+		}
+	}
+
+	channels := 3
+	if magic == "P2" || magic == "P5" {
+		channels = 1
+	}
+
+	var img draw.Image
+	switch {
+	case channels == 1 && maxVal < 256:
+		img = image.NewGray(rect)
+	case channels == 1:
+		img = image.NewGray16(rect)
+	case maxVal < 256:
+		img = image.NewRGBA(rect)
+	default:
+		img = image.NewRGBA64(rect)
+	}
+
+	// Samples are scaled to 16 bits, which the color models of 8 bit images round back exactly.
+	var sample [3]uint16
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			for i := 0; i < channels; i++ {
+				v, err := next()
+				if err != nil {
+					return nil, err
+				}
+
+				if v > maxVal {
+					return nil, fmt.Errorf("pnm sample %d is above the max value %d", v, maxVal)
+				}
+
+				if maxVal < 256 {
+					sample[i] = uint16((v*255+maxVal/2)/maxVal) * 0x101
+				} else {
+					sample[i] = uint16((v*65535 + maxVal/2) / maxVal)
+				}
+			}
+
+			if channels == 1 {
+				img.Set(x, y, color.Gray16{Y: sample[0]})
+			} else {
+				img.Set(x, y, color.RGBA64{R: sample[0], G: sample[1], B: sample[2], A: 0xFFFF})
+			}
+		}
+	}
+
+	return img, nil
+}
+
+// readPNMToken reads a whitespace separated header token, skipping comments.
+// It consumes exactly one whitespace character after the token, as required before the raster.
+// The last sample of an ASCII raster may end the file.
+func readPNMToken(br *bufio.Reader) (string, error) {
+	var token []byte
+
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF && len(token) > 0 {
+			return string(token), nil
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		switch {
+		case b == '#' && len(token) == 0:
+			if _, err := br.ReadString('\n'); err != nil {
+				return "", err
+			}
+		case b == ' ' || b == '\t' || b == '\n' || b == '\r':
+			if len(token) > 0 {
+				return string(token), nil
+			}
+		default:
+			token = append(token, b)
+		}
+	}
+}
+
+// rgbImage is an opaque image with 8-bit sRGB samples packed like the raster of a binary PPM.
+type rgbImage struct {
+	Pix    []uint8
+	Stride int
+	Rect   image.Rectangle
+}
+
+func newRGBImage(r image.Rectangle) *rgbImage {
+	return &rgbImage{Pix: make([]uint8, 3*r.Dx()*r.Dy()), Stride: 3 * r.Dx(), Rect: r}
+}
+
+func (p *rgbImage) ColorModel() color.Model { return color.RGBAModel }
+
+func (p *rgbImage) Bounds() image.Rectangle { return p.Rect }
+
+func (p *rgbImage) Opaque() bool { return true }
+
+func (p *rgbImage) At(x, y int) color.Color { return p.RGBAAt(x, y) }
+
+func (p *rgbImage) RGBA64At(x, y int) color.RGBA64 {
+	r, g, b, a := p.RGBAAt(x, y).RGBA()
+	return color.RGBA64{R: uint16(r), G: uint16(g), B: uint16(b), A: uint16(a)}
+}
+
+func (p *rgbImage) RGBAAt(x, y int) color.RGBA {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return color.RGBA{}
+	}
+
+	i := p.PixOffset(x, y)
+	return color.RGBA{R: p.Pix[i], G: p.Pix[i+1], B: p.Pix[i+2], A: 0xFF}
+}
+
+func (p *rgbImage) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*3
+}
+
+// writePNM writes img as a binary PGM if it's *image.Gray, or as a binary PPM otherwise.
+// Alpha is ignored, like in image/jpeg.
+func writePNM(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	bw := bufio.NewWriter(w)
+
+	if gray, ok := img.(*image.Gray); ok {
+		fmt.Fprintf(bw, "P5\n%d %d\n255\n", width, height)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			bw.Write(gray.Pix[gray.PixOffset(bounds.Min.X, y):][:width])
+		}
+
+		return bw.Flush()
+	}
+
+	fmt.Fprintf(bw, "P6\n%d %d\n255\n", width, height)
+
+	if rgb, ok := img.(*rgbImage); ok {
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			bw.Write(rgb.Pix[rgb.PixOffset(bounds.Min.X, y):][:width*3])
+		}
+
+		return bw.Flush()
+	}
+
+	row := make([]byte, width*3)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		if rgba, ok := img.(*image.RGBA); ok {
+			src := rgba.Pix[rgba.PixOffset(bounds.Min.X, y):]
+			for x := 0; x < width; x++ {
+				row[x*3], row[x*3+1], row[x*3+2] = src[x*4], src[x*4+1], src[x*4+2]
+			}
+		} else {
+			for x := 0; x < width; x++ {
+				r, g, b, _ := img.At(bounds.Min.X+x, y).RGBA()
+				row[x*3], row[x*3+1], row[x*3+2] = uint8(r>>8), uint8(g>>8), uint8(b>>8)
+			}
+		}
+
+		bw.Write(row)
+	}
+
+	return bw.Flush()
+}
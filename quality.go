@@ -0,0 +1,105 @@
+package mozjpegbin
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// quantTables returns the quantization tables defined in segments, by table id, in natural order.
+func quantTables(segments []segment) (map[int][64]int, error) {
+	tables := map[int][64]int{}
+
+	for _, s := range segments {
+		if s.marker != markerDQT {
+			continue
+		}
+
+		data := s.data
+		for len(data) > 0 {
+			precision, id := data[0]>>4, int(data[0]&0x0F)
+			size := 64
+			if precision == 1 {
+				size = 128
+			}
+
+			if precision > 1 || id > 3 || len(data) < 1+size {
+				return nil, errors.New("invalid DQT segment")
+			}
+
+			var table [64]int
+			for i := range table {
+				if precision == 1 {
+					table[zigzag[i]] = int(binary.BigEndian.Uint16(data[1+i*2:]))
+				} else {
+					table[zigzag[i]] = int(data[1+i])
+				}
+			}
+
+			tables[id] = table
+			data = data[1+size:]
+		}
+	}
+
+	return tables, nil
+}
+
+// estimateQuality returns the IJG quality whose scaled standard tables are closest to tables.
+// Table 0 is compared with the luminance table and table 1, if present, with the chrominance table.
+// Files produced with other base tables, like mozjpeg's default ones, get the IJG quality of similar strength.
+func estimateQuality(tables map[int][64]int) (int, error) {
+	luma, ok := tables[0]
+	if !ok {
+		return 0, errors.New("no luminance quantization table found")
+	}
+
+	chroma, hasChroma := tables[1]
+	best, bestDiff := 0, -1
+
+	for quality := 1; quality <= 100; quality++ {
+		diff := tableDiff(scaleQuant(&stdLuminanceQuant, quality), luma)
+		if hasChroma {
+			diff += tableDiff(scaleQuant(&stdChrominanceQuant, quality), chroma)
+		}
+
+		// Ties go to the higher quality, so that capping never degrades the image further.
+		if bestDiff < 0 || diff <= bestDiff {
+			best, bestDiff = quality, diff
+		}
+	}
+
+	return best, nil
+}
+
+func tableDiff(a, b [64]int) int {
+	var diff int
+	for i := range a {
+		d := a[i] - min(b[i], 255)
+		if d < 0 {
+			d = -d
+		}
+		diff += d
+	}
+
+	return diff
+}
+
+// estimateJPEGQuality estimates the quality data was encoded with.
+func estimateJPEGQuality(data []byte) (int, error) {
+	segments, _, err := splitHeader(data)
+	if err != nil {
+		return 0, err
+	}
+
+	tables, err := quantTables(segments)
+	if err != nil {
+		return 0, err
+	}
+
+	quality, err := estimateQuality(tables)
+	if err != nil {
+		return 0, fmt.Errorf("failed to estimate quality: %v", err)
+	}
+
+	return quality, nil
+}
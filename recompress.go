@@ -0,0 +1,132 @@
+package mozjpegbin
+
+import (
+	"bytes"
+	"context"
+)
+
+// Recompression tells which output SmartRecompress kept.
+type Recompression int
+
+const (
+	// Reencoded means the input was encoded again with cjpeg.
+	Reencoded Recompression = iota
+	// Optimized means the input was optimized losslessly with jpegtran.
+	Optimized
+	// Original means neither saved enough and the input was kept as is.
+	Original
+)
+
+// String returns the name of the recompression.
+func (r Recompression) String() string {
+	switch r {
+	case Reencoded:
+		return "reencoded"
+	case Optimized:
+		return "optimized"
+	case Original:
+		return "original"
+	default:
+		return "unknown"
+	}
+}
+
+// SmartRecompress makes sure JPEG inputs are never inflated or degraded.
+//
+// The quality of a JPEG input is estimated from its quantization tables and the requested quality
+// (or the highest quality tried by TargetSize and TargetQuality) is capped at it.
+// The input is also optimized losslessly with jpegtran. The smaller of both outputs is written,
+// unless it saves less than minSavingPercent percent of the input size, in which case the input is written unchanged.
+// Result reports the estimated quality and which output was kept. Inputs other than JPEG are encoded as usual.
+// Without a MetadataPolicy, metadata isn't kept by either output, but is kept along with the original.
+func (c *CJpeg) SmartRecompress(minSavingPercent float64) *CJpeg {
+	c.smart = true
+	c.minSaving = max(minSavingPercent, 0)
+	return c
+}
+
+// recompress encodes a JPEG input following SmartRecompress.
+// It reports false when the input isn't a JPEG file.
+func (c *CJpeg) recompress(ctx context.Context, in *cjpegInput, icc []byte) ([]byte, *EncodeResult, bool, error) {
+	if c.inputImage != nil || c.resizing() {
+		return nil, nil, false, nil
+	}
+
+	source, err := in.bytes()
+	if err != nil {
+		return nil, nil, true, err
+	}
+
+	if !isJPEG(source) {
+		return nil, nil, false, nil
+	}
+
+	estimated, err := estimateJPEGQuality(source)
+	if err != nil {
+		return nil, nil, true, err
+	}
+
+	quality := c.maxQuality()
+	if c.quality < 0 && c.targetSize == 0 && c.target == nil {
+		quality = defaultQuality
+	}
+
+	trial := *c
+	trial.smart = false
+	trial.quality = min(quality, estimated)
+
+	data, result, err := trial.encodeBuffered(ctx, in, icc)
+	if err != nil {
+		return nil, nil, true, err
+	}
+
+	result.SourceQuality = estimated
+
+	var optimized bytes.Buffer
+	_, err = runTool(ctx, c.runner(), &Invocation{
+		Tool:   "jpegtran",
+		Args:   []string{"-optimize", "-copy", "none"},
+		Stdin:  bytes.NewReader(source),
+		Stdout: &optimized,
+	})
+	if err != nil {
+		return nil, nil, true, err
+	}
+
+	result.Trials++
+
+	kept, err := c.postProcess(in, optimized.Bytes(), icc)
+	if err != nil {
+		return nil, nil, true, err
+	}
+
+	if len(kept) < len(data) {
+		data = kept
+		result.Recompression = Optimized
+	}
+
+	saving := float64(len(source)-len(data)) * 100 / float64(len(source))
+	if saving < c.minSaving || len(data) >= len(source) {
+		if data, err = c.postProcess(in, source, icc); err != nil {
+			return nil, nil, true, err
+		}
+
+		result.Recompression = Original
+	}
+
+	if result.Recompression != Reencoded {
+		// Both keep the pixels of the input.
+		result.Quality = estimated
+		if c.target != nil {
+			result.Score = 1
+		}
+	}
+
+	result.Size = len(data)
+	return data, result, true, nil
+}
+
+// isJPEG reports whether data starts like a JPEG file.
+func isJPEG(data []byte) bool {
+	return len(data) > 2 && data[0] == 0xFF && data[1] == markerSOI && data[2] == 0xFF
+}
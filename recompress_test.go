@@ -0,0 +1,76 @@
+package mozjpegbin_test
+
+import (
+	"bytes"
+	"image/jpeg"
+	"os"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeStdJpeg(t *testing.T, quality int) []byte {
+	f, err := os.Open("source.jpg")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer f.Close()
+
+	img, err := jpeg.Decode(f)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var buf bytes.Buffer
+	if !assert.Nil(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})) {
+		t.FailNow()
+	}
+
+	return buf.Bytes()
+}
+
+func TestCJpegSmartRecompressCapsQuality(t *testing.T) {
+	for _, quality := range []int{40, 60} {
+		source := encodeStdJpeg(t, quality)
+
+		c, err := mozjpegbin.NewCJpeg()
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+
+		var out bytes.Buffer
+		err = c.Input(bytes.NewReader(source)).Output(&out).Quality(90).SmartRecompress(5).Run()
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+
+		result := c.Result()
+		assert.Equal(t, quality, result.SourceQuality)
+		assert.LessOrEqual(t, result.Quality, quality)
+		assert.NotEqual(t, mozjpegbin.Original, result.Recompression)
+		assert.Less(t, out.Len(), len(source)*95/100)
+	}
+}
+
+func TestCJpegSmartRecompressKeepsOriginal(t *testing.T) {
+	c, err := mozjpegbin.NewCJpeg()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var optimized bytes.Buffer
+	err = c.Input(bytes.NewReader(encodeStdJpeg(t, 50))).Output(&optimized).Quality(50).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var out bytes.Buffer
+	err = c.Input(bytes.NewReader(optimized.Bytes())).Output(&out).Quality(90).SmartRecompress(10).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, mozjpegbin.Original, c.Result().Recompression)
+	assert.Equal(t, optimized.Bytes(), out.Bytes())
+}
@@ -0,0 +1,186 @@
+package mozjpegbin
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// ResizeMode tells how an image is fitted into the box given to Resize.
+type ResizeMode int
+
+const (
+	// ResizeFit scales the image down to fit within the box, keeping its aspect ratio. Smaller images are left alone.
+	ResizeFit ResizeMode = iota
+	// ResizeFill scales the image to cover the box, keeping its aspect ratio, and crops the overflow evenly on both sides.
+	ResizeFill
+	// ResizeExact scales the image to the size of the box, ignoring its aspect ratio.
+	ResizeExact
+)
+
+// Lanczos is the Lanczos-3 kernel. It's sharper than draw.CatmullRom, at the cost of slightly more ringing.
+var Lanczos = &draw.Kernel{Support: 3, At: func(t float64) float64 {
+	if t == 0 {
+		return 1
+	} else if t >= 3 {
+		return 0
+	}
+
+	x := math.Pi * t
+	return 3 * math.Sin(x) * math.Sin(x/3) / (x * x)
+}}
+
+// Resize describes how an image is scaled before being encoded.
+type Resize struct {
+	// Width and Height are the size of the box. A zero dimension doesn't constrain the image.
+	Width  int
+	Height int
+	Mode   ResizeMode
+	// Filter is the resampling kernel, like Lanczos. draw.CatmullRom is used if nil.
+	Filter *draw.Kernel
+}
+
+// plan returns the part of an image of bounds b to scale and the size to scale it to.
+// It returns false when the image is left as is.
+func (r *Resize) plan(b image.Rectangle) (image.Rectangle, int, int, bool) {
+	w, h := b.Dx(), b.Dy()
+	if r == nil || (r.Width <= 0 && r.Height <= 0) || w == 0 || h == 0 {
+		return b, w, h, false
+	}
+
+	width, height := r.Width, r.Height
+	mode := r.Mode
+
+	if mode == ResizeFill && (width <= 0 || height <= 0) {
+		mode = ResizeFit
+	}
+
+	switch mode {
+	case ResizeExact:
+		if width <= 0 {
+			width = w
+		}
+
+		if height <= 0 {
+			height = h
+		}
+	case ResizeFill:
+		scale := max(float64(width)/float64(w), float64(height)/float64(h))
+		cw := min(w, max(1, int(math.Round(float64(width)/scale))))
+		ch := min(h, max(1, int(math.Round(float64(height)/scale))))
+		x, y := b.Min.X+(w-cw)/2, b.Min.Y+(h-ch)/2
+		b = image.Rect(x, y, x+cw, y+ch)
+	default:
+		scale := math.Inf(1)
+		if width > 0 {
+			scale = float64(width) / float64(w)
+		}
+
+		if height > 0 {
+			scale = min(scale, float64(height)/float64(h))
+		}
+
+		if scale >= 1 {
+			return b, w, h, false
+		}
+
+		width = max(1, int(math.Round(float64(w)*scale)))
+		height = max(1, int(math.Round(float64(h)*scale)))
+	}
+
+	return b, width, height, width != w || height != h || b.Dx() != w || b.Dy() != h
+}
+
+// resizeImage composites img over bg and scales it following r, in linear light.
+// Compositing is fused with the conversion to linear light, so img isn't copied.
+// Without resizing it's the same as flatten.
+func resizeImage(img image.Image, bg color.Color, r *Resize) image.Image {
+	sr, width, height, ok := r.plan(img.Bounds())
+	if !ok {
+		return flatten(img, bg)
+	}
+
+	return resample(img, bg, sr, width, height, r.Filter)
+}
+
+// resample composites the part sr of img over bg and scales it to width x height, in linear light.
+// Pixels of img are converted as the filter reads them, and the filter's output is stored as sRGB,
+// so that the only full-size buffer is the raster of the result.
+func resample(img image.Image, bg color.Color, sr image.Rectangle, width, height int, filter *draw.Kernel) image.Image {
+	if bg == nil {
+		bg = color.White
+	}
+
+	background := color.NRGBAModel.Convert(bg).(color.NRGBA)
+	src := &linearImage{
+		img: img,
+		bg:  [3]float64{srgbToLinear[background.R], srgbToLinear[background.G], srgbToLinear[background.B]},
+	}
+
+	if filter == nil {
+		filter = draw.CatmullRom
+	}
+
+	out := newRGBImage(image.Rect(0, 0, width, height))
+	filter.Scale(srgbImage{out}, out.Bounds(), src, sr, draw.Src, nil)
+	return out
+}
+
+// srgbToLinear16 maps 8-bit sRGB values to 16-bit linear light.
+var srgbToLinear16 = func() (table [256]uint16) {
+	for i, v := range srgbToLinear {
+		table[i] = uint16(math.Round(v * 0xFFFF))
+	}
+
+	return table
+}()
+
+// linearImage is img composited over bg and converted to linear light on demand.
+type linearImage struct {
+	img image.Image
+	bg  [3]float64
+}
+
+func (l *linearImage) ColorModel() color.Model { return color.RGBA64Model }
+
+func (l *linearImage) Bounds() image.Rectangle { return l.img.Bounds() }
+
+func (l *linearImage) At(x, y int) color.Color { return l.RGBA64At(x, y) }
+
+func (l *linearImage) RGBA64At(x, y int) color.RGBA64 {
+	c := straightAt(l.img, x, y)
+	if c.A == 0xFF {
+		return color.RGBA64{R: srgbToLinear16[c.R], G: srgbToLinear16[c.G], B: srgbToLinear16[c.B], A: 0xFFFF}
+	}
+
+	a := float64(c.A) / 255
+	var linear [3]uint16
+	for j, v := range [3]uint8{c.R, c.G, c.B} {
+		linear[j] = uint16(math.Round((a*srgbToLinear[v] + (1-a)*l.bg[j]) * 0xFFFF))
+	}
+
+	return color.RGBA64{R: linear[0], G: linear[1], B: linear[2], A: 0xFFFF}
+}
+
+// srgbImage stores the linear light colors set by draw.Kernel.Scale as sRGB.
+type srgbImage struct {
+	*rgbImage
+}
+
+func (s srgbImage) Set(x, y int, c color.Color) {
+	s.SetRGBA64(x, y, color.RGBA64Model.Convert(c).(color.RGBA64))
+}
+
+func (s srgbImage) SetRGBA64(x, y int, c color.RGBA64) {
+	if !(image.Point{x, y}.In(s.Rect)) {
+		return
+	}
+
+	scale := float64(len(linearToSRGB)-1) / 0xFFFF
+	i := s.PixOffset(x, y)
+	for j, v := range [3]uint16{c.R, c.G, c.B} {
+		s.Pix[i+j] = linearToSRGB[int(float64(v)*scale+0.5)]
+	}
+}
@@ -0,0 +1,104 @@
+package mozjpegbin_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/draw"
+)
+
+func encodedBounds(t *testing.T, data []byte) image.Rectangle {
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	return image.Rect(0, 0, cfg.Width, cfg.Height)
+}
+
+func TestCJpegResizeModes(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i)
+	}
+
+	tests := []struct {
+		name          string
+		width, height int
+		mode          mozjpegbin.ResizeMode
+		want          image.Rectangle
+	}{
+		{"fit", 100, 100, mozjpegbin.ResizeFit, image.Rect(0, 0, 100, 75)},
+		{"fit width", 200, 0, mozjpegbin.ResizeFit, image.Rect(0, 0, 200, 150)},
+		{"fit no upscale", 800, 800, mozjpegbin.ResizeFit, image.Rect(0, 0, 400, 300)},
+		{"fill", 100, 100, mozjpegbin.ResizeFill, image.Rect(0, 0, 100, 100)},
+		{"exact", 50, 80, mozjpegbin.ResizeExact, image.Rect(0, 0, 50, 80)},
+	}
+
+	for _, test := range tests {
+		c, err := mozjpegbin.NewCJpeg()
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+
+		var out bytes.Buffer
+		err = c.Resize(test.width, test.height, mozjpegbin.Lanczos).
+			ResizeMode(test.mode).
+			InputImage(img).
+			Output(&out).
+			Run()
+		if assert.Nil(t, err, test.name) {
+			assert.Equal(t, test.want, encodedBounds(t, out.Bytes()), test.name)
+		}
+	}
+}
+
+func TestCJpegResizeFile(t *testing.T) {
+	c, err := mozjpegbin.NewCJpeg()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var out bytes.Buffer
+	err = c.Resize(300, 300, draw.CatmullRom).InputFile("source.jpg").Output(&out).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, image.Rect(0, 0, 300, 225), encodedBounds(t, out.Bytes()))
+}
+
+func TestEncodeResizeLinearLight(t *testing.T) {
+	// A fine black and white checkerboard averages to 50% linear light, which is 188 in sRGB, not 128.
+	img := image.NewGray(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			if (x+y)%2 == 0 {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	err := mozjpegbin.Encode(&out, img, &mozjpegbin.Options{
+		Quality: 95,
+		Resize:  &mozjpegbin.Resize{Width: 16, Height: 16, Mode: mozjpegbin.ResizeExact, Filter: mozjpegbin.Lanczos},
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	decoded, err := jpeg.Decode(&out)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, image.Rect(0, 0, 16, 16), decoded.Bounds())
+	r, _, _, _ := decoded.At(8, 8).RGBA()
+	assert.InDelta(t, 188, int(r>>8), 4)
+}
@@ -0,0 +1,276 @@
+package mozjpegbin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Munchpass/go-mozjpegbin/embedbinwrapper"
+)
+
+// Invocation describes a single run of a mozjpeg tool.
+type Invocation struct {
+	// Tool is the name of the tool to run: "cjpeg", "jpegtran" or "djpeg".
+	Tool string
+	// Args are the command line arguments, without the tool name.
+	Args []string
+	// Stdin is the tool's standard input. It may be nil.
+	Stdin io.Reader
+	// Stdout receives the tool's standard output.
+	// If nil, standard output is collected into RunResult.Stdout.
+	Stdout io.Writer
+}
+
+// RunResult is the outcome of an Invocation.
+type RunResult struct {
+	// Stdout is the collected standard output. It's empty if Invocation.Stdout was set.
+	Stdout []byte
+	// Stderr is the collected standard error.
+	Stderr []byte
+}
+
+// Runner runs mozjpeg tools.
+//
+// A Runner returns an error if the tool could not be started or exited unsuccessfully.
+// The result should be returned alongside the error whenever the tool produced any output,
+// so that callers can report its stderr.
+type Runner interface {
+	Run(ctx context.Context, inv *Invocation) (*RunResult, error)
+}
+
+var (
+	defaultRunnerMu sync.RWMutex
+	defaultRunner   Runner = NewEmbeddedRunner()
+)
+
+// DefaultRunner returns the Runner used by NewCJpeg and NewJpegTran.
+// Unless changed with SetDefaultRunner it's an EmbeddedRunner, or a CgoRunner when built with the mozjpeg_cgo tag.
+func DefaultRunner() Runner {
+	defaultRunnerMu.RLock()
+	defer defaultRunnerMu.RUnlock()
+	return defaultRunner
+}
+
+// SetDefaultRunner sets the Runner used by NewCJpeg and NewJpegTran.
+func SetDefaultRunner(r Runner) error {
+	if r == nil {
+		return errors.New("runner must not be nil")
+	}
+
+	defaultRunnerMu.Lock()
+	defer defaultRunnerMu.Unlock()
+	defaultRunner = r
+	return nil
+}
+
+// EmbeddedRunner runs the mozjpeg binaries embedded into this package.
+// It's safe for concurrent use.
+type EmbeddedRunner struct {
+	timeout   time.Duration
+	strategy  embedbinwrapper.ExecStrategy
+	skipCJpeg atomic.Bool
+}
+
+// NewEmbeddedRunner creates EmbeddedRunner instance
+func NewEmbeddedRunner() *EmbeddedRunner {
+	return &EmbeddedRunner{}
+}
+
+// Timeout sets timeout for every run. By default it's 0 (binary will run till end).
+func (r *EmbeddedRunner) Timeout(timeout time.Duration) *EmbeddedRunner {
+	r.timeout = timeout
+	return r
+}
+
+// Strategy sets how binaries are made executable. By default embedbinwrapper.DefaultStrategy is used.
+func (r *EmbeddedRunner) Strategy(strategy embedbinwrapper.ExecStrategy) *EmbeddedRunner {
+	r.strategy = strategy
+	return r
+}
+
+// SkipCJpeg makes CJpeg work without running cjpeg, for systems where it can't start,
+// like minimal containers without libpng, which the embedded Linux cjpeg links dynamically.
+// Inputs are then decoded in Go, encoded as baseline JPEG in Go and optimized with jpegtran.
+// Outputs are larger than cjpeg's, as trellis quantization isn't applied, and Targa inputs aren't supported.
+// CJpeg returns an error if QuantTables, Arithmetic or Revert is set.
+// SelfTest enables it when needed.
+func (r *EmbeddedRunner) SkipCJpeg(skip bool) *EmbeddedRunner {
+	r.skipCJpeg.Store(skip)
+	return r
+}
+
+// Available returns an error if there is no embedded binary of tool for the current platform.
+func (r *EmbeddedRunner) Available(tool string) error {
+	_, err := createBinWrapper(tool)
+	return err
+}
+
+// binary returns the embedded binary of tool.
+func (r *EmbeddedRunner) binary(tool string) ([]byte, error) {
+	bin, _, err := embeddedBinary(tool)
+	return bin, err
+}
+
+// Run runs the embedded binary of inv.Tool.
+func (r *EmbeddedRunner) Run(ctx context.Context, inv *Invocation) (*RunResult, error) {
+	b, err := createBinWrapper(inv.Tool)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.run(ctx, b, inv)
+}
+
+// run runs inv with b. The Timeout and Strategy of r, when set, replace the ones of b.
+func (r *EmbeddedRunner) run(ctx context.Context, b *embedbinwrapper.EmbedBinWrapper, inv *Invocation) (*RunResult, error) {
+	if r.timeout > 0 {
+		b.Timeout(r.timeout)
+	}
+
+	if r.strategy != nil {
+		b.Strategy(r.strategy)
+	}
+
+	if inv.Stdin != nil {
+		b.StdIn(inv.Stdin)
+	}
+
+	if inv.Stdout != nil {
+		b.SetStdOut(inv.Stdout)
+	}
+
+	err := b.RunContext(ctx, inv.Args...)
+	return &RunResult{Stdout: b.StdOut(), Stderr: b.StdErr()}, err
+}
+
+// binWrapperRunner runs tool with a copy of the deprecated BinWrapper of a CJpeg or a JpegTran,
+// so that settings made on it keep applying, and other tools with the EmbeddedRunner.
+type binWrapperRunner struct {
+	*EmbeddedRunner
+	tool    string
+	wrapper *embedbinwrapper.EmbedBinWrapper
+}
+
+func (r *binWrapperRunner) Run(ctx context.Context, inv *Invocation) (*RunResult, error) {
+	if inv.Tool != r.tool {
+		return r.EmbeddedRunner.Run(ctx, inv)
+	}
+
+	return r.run(ctx, r.wrapper.Clone(), inv)
+}
+
+// embeddedBinWrapper returns the BinWrapper of tool for a CJpeg or a JpegTran running with runner, nil if runner isn't an EmbeddedRunner.
+func embeddedBinWrapper(runner Runner, tool string) *embedbinwrapper.EmbedBinWrapper {
+	if _, ok := runner.(*EmbeddedRunner); !ok {
+		return nil
+	}
+
+	b, err := createBinWrapper(tool)
+	if err != nil {
+		return nil
+	}
+
+	return b
+}
+
+// withBinWrapper returns the Runner running tool for a CJpeg or a JpegTran with runner and wrapper, its BinWrapper.
+func withBinWrapper(runner Runner, tool string, wrapper *embedbinwrapper.EmbedBinWrapper) Runner {
+	if embedded, ok := runner.(*EmbeddedRunner); ok && wrapper != nil {
+		return &binWrapperRunner{EmbeddedRunner: embedded, tool: tool, wrapper: wrapper}
+	}
+
+	return runner
+}
+
+// ExecRunner runs mozjpeg tools installed on the system as regular subprocesses.
+// It's safe for concurrent use once configured.
+type ExecRunner struct {
+	paths map[string]string
+}
+
+// NewExecRunner creates ExecRunner instance. Tools are looked up in PATH unless set with Path.
+func NewExecRunner() *ExecRunner {
+	return &ExecRunner{paths: map[string]string{}}
+}
+
+// Path sets the executable to run for tool.
+func (r *ExecRunner) Path(tool, path string) *ExecRunner {
+	r.paths[tool] = path
+	return r
+}
+
+// Run runs inv.Tool as a subprocess.
+func (r *ExecRunner) Run(ctx context.Context, inv *Invocation) (*RunResult, error) {
+	path, err := r.path(inv.Tool)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, inv.Args...)
+	cmd.Stdin = inv.Stdin
+	cmd.Stderr = &stderr
+
+	if inv.Stdout != nil {
+		cmd.Stdout = inv.Stdout
+	} else {
+		cmd.Stdout = &stdout
+	}
+
+	err = cmd.Run()
+
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+
+	return &RunResult{Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}, err
+}
+
+// binary returns the content of the executable of tool.
+func (r *ExecRunner) binary(tool string) ([]byte, error) {
+	path, err := r.path(tool)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(path)
+}
+
+func (r *ExecRunner) path(tool string) (string, error) {
+	if path, ok := r.paths[tool]; ok {
+		return path, nil
+	}
+
+	path, err := exec.LookPath(tool)
+	if err != nil {
+		return "", fmt.Errorf("failed to find %s: %v", tool, err)
+	}
+
+	return path, nil
+}
+
+// runTool runs inv with r and folds the tool's stderr into the returned error.
+func runTool(ctx context.Context, r Runner, inv *Invocation) (*RunResult, error) {
+	res, err := r.Run(ctx, inv)
+
+	if err != nil {
+		if res != nil && len(res.Stderr) > 0 {
+			if library, ok := missingLibrary(res.Stderr); ok {
+				return res, &MissingLibraryError{Tool: inv.Tool, Library: library, Err: err}
+			}
+
+			return res, errors.New(err.Error() + ". " + string(res.Stderr))
+		}
+
+		return res, err
+	}
+
+	return res, nil
+}
@@ -0,0 +1,107 @@
+package mozjpegbin_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRunner records invocations and writes a canned output instead of running tools.
+type fakeRunner struct {
+	invocations []*mozjpegbin.Invocation
+	stdin       [][]byte
+	output      []byte
+	err         error
+}
+
+func (r *fakeRunner) Run(ctx context.Context, inv *mozjpegbin.Invocation) (*mozjpegbin.RunResult, error) {
+	r.invocations = append(r.invocations, inv)
+
+	var stdin []byte
+	if inv.Stdin != nil {
+		stdin, _ = io.ReadAll(inv.Stdin)
+	}
+	r.stdin = append(r.stdin, stdin)
+
+	if r.err != nil {
+		return &mozjpegbin.RunResult{Stderr: []byte("fake failure")}, r.err
+	}
+
+	if inv.Stdout != nil {
+		_, err := inv.Stdout.Write(r.output)
+		return &mozjpegbin.RunResult{}, err
+	}
+
+	return &mozjpegbin.RunResult{Stdout: r.output}, nil
+}
+
+func TestCJpegWithRunner(t *testing.T) {
+	r := &fakeRunner{output: []byte("jpeg")}
+	var out bytes.Buffer
+
+	err := mozjpegbin.NewCJpegWithRunner(r).
+		Quality(80).
+		Optimize(true).
+		Input(strings.NewReader("P6\n1 1\n255\nrgb")).
+		Output(&out).
+		Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	if !assert.Len(t, r.invocations, 1) {
+		t.FailNow()
+	}
+	assert.Equal(t, "cjpeg", r.invocations[0].Tool)
+	assert.Equal(t, []string{"-quality", "80", "-optimize"}, r.invocations[0].Args)
+	assert.Equal(t, "P6\n1 1\n255\nrgb", string(r.stdin[0]))
+	assert.Equal(t, "jpeg", out.String())
+}
+
+func TestJpegTranWithRunner(t *testing.T) {
+	r := &fakeRunner{}
+
+	err := mozjpegbin.NewJpegTranWithRunner(r).
+		Crop(1, 2, 3, 4).
+		InputFile("in.jpg").
+		OutputFile("out.jpg").
+		Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, "jpegtran", r.invocations[0].Tool)
+	assert.Equal(t, []string{"-optimize", "-crop", "3x4+1+2", "-copy", "none", "-outfile", "out.jpg", "in.jpg"}, r.invocations[0].Args)
+}
+
+func TestRunnerErrorIncludesStderr(t *testing.T) {
+	r := &fakeRunner{err: errors.New("exit status 1")}
+
+	err := mozjpegbin.NewJpegTranWithRunner(r).
+		InputFile("in.jpg").
+		OutputFile("out.jpg").
+		Run()
+
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "exit status 1. fake failure", err.Error())
+	}
+}
+
+func TestEmbeddedRunnerContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c, err := mozjpegbin.NewCJpeg()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	err = c.InputFile("source.jpg").Output(io.Discard).RunContext(ctx)
+	assert.NotNil(t, err)
+}
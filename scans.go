@@ -0,0 +1,232 @@
+package mozjpegbin
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ScanInfo describes a scan of a JPEG file. A progressive file can be cut at the End of any scan
+// (and terminated with an EOI marker) to get a lower quality version of the image.
+type ScanInfo struct {
+	// Start is the offset of the SOS marker of the scan.
+	Start int
+	// End is the offset just past the entropy coded data of the scan, where the next marker starts.
+	End int
+	// Components are the ids of the components coded in the scan.
+	Components []int
+	// Ss and Se are the first and last coefficients of the spectral band of the scan, in zig-zag order.
+	Ss, Se int
+	// Ah and Al are the successive approximation bit positions, Ah is 0 in the first scan of a band.
+	Ah, Al int
+}
+
+// ScanIndex returns the scans of the JPEG file read from r.
+func ScanIndex(r io.Reader) ([]ScanInfo, error) {
+	var idx scanIndexer
+	if _, err := io.Copy(&idx, r); err != nil {
+		return nil, err
+	}
+
+	return idx.result()
+}
+
+// indexScans returns the scans of data, nil if it isn't a valid JPEG file.
+func indexScans(data []byte) []ScanInfo {
+	var idx scanIndexer
+	idx.Write(data)
+	scans, _ := idx.result()
+	return scans
+}
+
+// scanFileIndex returns the scans of the JPEG file named name.
+func scanFileIndex(name string) ([]ScanInfo, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	return ScanIndex(f)
+}
+
+// scanIndexState is the part of a JPEG file a scanIndexer is in.
+type scanIndexState int
+
+const (
+	stateSOI scanIndexState = iota
+	stateMarker
+	stateMarkerCode
+	stateLength
+	statePayload
+	stateEntropy
+	stateEntropyMarker
+	stateDone
+)
+
+// scanIndexer finds the scans of a JPEG file written to it, without keeping the file in memory.
+type scanIndexer struct {
+	state  scanIndexState
+	pos    int
+	marker byte
+	// markerPos is the offset of the marker being read.
+	markerPos int
+	length    []byte
+	remaining int
+	// sos collects the payload of an SOS segment.
+	sos   []byte
+	scans []ScanInfo
+	err   error
+}
+
+func (x *scanIndexer) Write(p []byte) (int, error) {
+	for i := 0; i < len(p) && x.err == nil && x.state != stateDone; {
+		b := p[i]
+
+		switch x.state {
+		case stateSOI:
+			if x.pos == 0 && b != 0xFF || x.pos == 1 && b != markerSOI {
+				x.err = errors.New("not a jpeg file: missing SOI marker")
+			} else if x.pos == 1 {
+				x.state = stateMarker
+			}
+		case stateMarker:
+			if b != 0xFF {
+				x.err = fmt.Errorf("invalid jpeg marker at offset %d", x.pos)
+				break
+			}
+
+			x.markerPos = x.pos
+			x.state = stateMarkerCode
+		case stateMarkerCode:
+			if b == 0xFF {
+				// Fill byte.
+				x.markerPos = x.pos
+				break
+			}
+
+			x.startMarker(b)
+		case stateLength:
+			x.length = append(x.length, b)
+			if len(x.length) == 2 {
+				x.remaining = int(x.length[0])<<8 | int(x.length[1]) - 2
+				if x.remaining < 0 {
+					x.err = fmt.Errorf("invalid length of jpeg marker 0x%X at offset %d", x.marker, x.markerPos)
+					break
+				}
+
+				x.state = statePayload
+				if x.remaining == 0 {
+					x.endSegment()
+				}
+			}
+		case statePayload:
+			n := min(x.remaining, len(p)-i)
+			if x.marker == markerSOS {
+				x.sos = append(x.sos, p[i:i+n]...)
+			}
+
+			x.remaining -= n
+			x.pos += n
+			i += n
+
+			if x.remaining == 0 {
+				x.endSegment()
+			}
+
+			continue
+		case stateEntropy:
+			j := i
+			for j < len(p) && p[j] != 0xFF {
+				j++
+			}
+
+			x.pos += j - i
+			i = j
+
+			if i < len(p) {
+				x.markerPos = x.pos
+				x.state = stateEntropyMarker
+				x.pos++
+				i++
+			}
+
+			continue
+		case stateEntropyMarker:
+			switch {
+			case b == 0xFF:
+				x.markerPos = x.pos
+			case b == 0 || b >= markerRST0 && b <= markerRST0+7:
+				x.state = stateEntropy
+			default:
+				x.scans[len(x.scans)-1].End = x.markerPos
+				x.startMarker(b)
+			}
+		}
+
+		x.pos++
+		i++
+	}
+
+	return len(p), nil
+}
+
+// startMarker handles the code of the marker found at markerPos.
+func (x *scanIndexer) startMarker(code byte) {
+	x.marker = code
+
+	switch {
+	case code == markerEOI:
+		x.state = stateDone
+	case code == 0x01 || code >= markerRST0 && code <= markerRST0+7:
+		x.state = stateMarker
+	default:
+		x.length = x.length[:0]
+		x.sos = x.sos[:0]
+		x.state = stateLength
+	}
+}
+
+// endSegment handles the end of the payload of the current segment.
+func (x *scanIndexer) endSegment() {
+	x.state = stateMarker
+	if x.marker != markerSOS {
+		return
+	}
+
+	payload := x.sos
+	if len(payload) < 1 || len(payload) < 1+int(payload[0])*2+3 {
+		x.err = errors.New("invalid SOS segment")
+		return
+	}
+
+	n := int(payload[0])
+	scan := ScanInfo{Start: x.markerPos, Components: make([]int, n)}
+	for i := range n {
+		scan.Components[i] = int(payload[1+i*2])
+	}
+
+	rest := payload[1+n*2:]
+	scan.Ss, scan.Se = int(rest[0]), int(rest[1])
+	scan.Ah, scan.Al = int(rest[2]>>4), int(rest[2]&0x0F)
+
+	x.scans = append(x.scans, scan)
+	x.state = stateEntropy
+}
+
+// result returns the scans found, once the whole file was written.
+func (x *scanIndexer) result() ([]ScanInfo, error) {
+	if x.err != nil {
+		return nil, x.err
+	}
+
+	if x.state == stateEntropy || x.state == stateEntropyMarker {
+		// The file ends without an EOI marker.
+		x.scans[len(x.scans)-1].End = x.pos
+	} else if x.state != stateDone {
+		return nil, errors.New("invalid jpeg: truncated file")
+	}
+
+	return x.scans, nil
+}
@@ -0,0 +1,113 @@
+package mozjpegbin_test
+
+import (
+	"bytes"
+	"image/jpeg"
+	"os"
+	"testing"
+	"testing/iotest"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCJpegScans(t *testing.T) {
+	c, err := mozjpegbin.NewCJpeg()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var out bytes.Buffer
+	err = c.InputFile("source.jpg").Output(&out).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	scans := c.Result().Scans
+	data := out.Bytes()
+
+	info, err := mozjpegbin.Inspect(bytes.NewReader(data))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	if !assert.Len(t, scans, info.Scans) || !assert.Greater(t, len(scans), 1) {
+		t.FailNow()
+	}
+
+	// The first scan of a progressive file codes DC coefficients.
+	assert.Equal(t, 0, scans[0].Ss)
+	assert.Equal(t, 0, scans[0].Se)
+	assert.Equal(t, 0, scans[0].Ah)
+
+	for i, scan := range scans {
+		assert.Equal(t, []byte{0xFF, 0xDA}, data[scan.Start:scan.Start+2])
+		assert.Less(t, scan.Start, scan.End)
+		assert.NotEmpty(t, scan.Components)
+		if i > 0 {
+			assert.LessOrEqual(t, scans[i-1].End, scan.Start)
+		}
+	}
+	assert.Equal(t, len(data)-2, scans[len(scans)-1].End)
+
+	// Cutting the file after a scan gives a preview.
+	preview := append(bytes.Clone(data[:scans[1].End]), 0xFF, 0xD9)
+	img, err := jpeg.Decode(bytes.NewReader(preview))
+	if assert.Nil(t, err) {
+		assert.Equal(t, info.Width, img.Bounds().Dx())
+	}
+
+	// Reading the file byte by byte gives the same index.
+	byByte, err := mozjpegbin.ScanIndex(iotest.OneByteReader(bytes.NewReader(data)))
+	if assert.Nil(t, err) {
+		assert.Equal(t, scans, byByte)
+	}
+}
+
+func TestCJpegScansBaselineFile(t *testing.T) {
+	c, err := mozjpegbin.NewCJpeg()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	err = c.Baseline(true).InputFile("source.jpg").OutputFile("target.jpg").Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	scans := c.Result().Scans
+	if assert.Len(t, scans, 1) {
+		assert.Equal(t, []int{1, 2, 3}, scans[0].Components)
+		assert.Equal(t, 0, scans[0].Ss)
+		assert.Equal(t, 63, scans[0].Se)
+		assert.Equal(t, c.Result().Size-2, scans[0].End)
+	}
+}
+
+func TestJpegTranScans(t *testing.T) {
+	c, err := mozjpegbin.NewJpegTran()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	f, err := os.Open("source.jpg")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer f.Close()
+
+	var out bytes.Buffer
+	err = c.Progressive(true).Input(f).Output(&out).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	result := c.Result()
+	assert.Equal(t, out.Len(), result.Size)
+	assert.Greater(t, len(result.Scans), 1)
+
+	index, err := mozjpegbin.ScanIndex(&out)
+	if assert.Nil(t, err) {
+		assert.Equal(t, index, result.Scans)
+	}
+}
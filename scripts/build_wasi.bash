@@ -0,0 +1,32 @@
+#!/usr/bin/env bash
+# Builds cjpeg, jpegtran and djpeg as WASI modules into bin/wasi for the mozjpeg_wasi build tag.
+# Requires cmake and wasi-sdk (https://github.com/WebAssembly/wasi-sdk), pointed to by WASI_SDK_PATH.
+set -euo pipefail
+
+MOZJPEG_VERSION=3.3.1
+WASI_SDK_PATH=${WASI_SDK_PATH:-/opt/wasi-sdk}
+OUT_DIR=$(cd "$(dirname "$0")/.." && pwd)/bin/wasi
+WORK_DIR=$(mktemp -d)
+trap 'rm -rf "$WORK_DIR"' EXIT
+
+cd "$WORK_DIR"
+wget -q https://github.com/mozilla/mozjpeg/archive/v${MOZJPEG_VERSION}.tar.gz
+tar -xzf v${MOZJPEG_VERSION}.tar.gz
+cd mozjpeg-${MOZJPEG_VERSION}
+
+# No SIMD, shared libraries, TurboJPEG or libpng: WASI has no dlopen and no setjmp.
+cmake -G "Unix Makefiles" \
+    -DCMAKE_TOOLCHAIN_FILE="$WASI_SDK_PATH/share/cmake/wasi-sdk.cmake" \
+    -DWASI_SDK_PREFIX="$WASI_SDK_PATH" \
+    -DCMAKE_BUILD_TYPE=Release \
+    -DENABLE_SHARED=0 \
+    -DWITH_SIMD=0 \
+    -DWITH_TURBOJPEG=0 \
+    -DPNG_SUPPORTED=0 \
+    .
+make -j"$(nproc)" cjpeg-static jpegtran-static djpeg-static
+
+mkdir -p "$OUT_DIR"
+cp cjpeg-static "$OUT_DIR/cjpeg.wasm"
+cp jpegtran-static "$OUT_DIR/jpegtran.wasm"
+cp djpeg-static "$OUT_DIR/djpeg.wasm"
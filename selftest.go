@@ -0,0 +1,184 @@
+package mozjpegbin
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"regexp"
+	"strings"
+)
+
+// MissingLibraryError is returned when a tool can't start because the dynamic loader can't find a shared library it links,
+// like libpng16.so.16 for the embedded cjpeg on minimal Linux systems.
+type MissingLibraryError struct {
+	Tool    string
+	Library string
+	// Err is the error returned by the runner.
+	Err error
+}
+
+func (e *MissingLibraryError) Error() string {
+	return fmt.Sprintf("%s can't start, shared library %s is missing: %v", e.Tool, e.Library, e.Err)
+}
+
+func (e *MissingLibraryError) Unwrap() error {
+	return e.Err
+}
+
+// loaderError matches the message printed by the glibc and musl dynamic loaders for a missing library.
+var loaderError = regexp.MustCompile(`(?:error while loading shared libraries: |Error loading shared library |Error relocating .*?: )([^\s:]+)`)
+
+// missingLibrary returns the library named by a dynamic loader error in stderr, if any.
+func missingLibrary(stderr []byte) (string, bool) {
+	m := loaderError.FindSubmatch(stderr)
+	if m == nil {
+		return "", false
+	}
+
+	return string(m[1]), true
+}
+
+// ToolStatus is the result of SelfTest for a tool.
+type ToolStatus struct {
+	Tool string
+	// Version is the version printed by the tool, empty if it didn't run.
+	Version string
+	// Err is nil if the tool runs. It's a *MissingLibraryError if a shared library is missing.
+	Err error
+}
+
+// SelfTestReport is the result of SelfTest.
+type SelfTestReport struct {
+	Tools []ToolStatus
+	// SkipCJpeg reports whether CJpeg was switched to encode without cjpeg. See EmbeddedRunner.SkipCJpeg.
+	SkipCJpeg bool
+}
+
+// SelfTest runs every tool with the default runner to check it can start on this system, e.g. at program startup.
+//
+// If the default runner is an EmbeddedRunner and cjpeg can't start because a shared library is missing
+// while jpegtran runs, SelfTest works around it by enabling SkipCJpeg on the runner, and doesn't report an error.
+// Otherwise it returns an error for every tool that can't run. The report is returned in both cases.
+func SelfTest() (*SelfTestReport, error) {
+	runner := DefaultRunner()
+	report := &SelfTestReport{}
+	failed := map[string]error{}
+
+	for _, tool := range []string{"cjpeg", "jpegtran", "djpeg"} {
+		status := ToolStatus{Tool: tool}
+		status.Version, status.Err = version(runner, tool)
+		report.Tools = append(report.Tools, status)
+
+		if status.Err != nil {
+			failed[tool] = status.Err
+		}
+	}
+
+	var missing *MissingLibraryError
+	if embedded, ok := runner.(*EmbeddedRunner); ok && errors.As(failed["cjpeg"], &missing) && failed["jpegtran"] == nil {
+		embedded.SkipCJpeg(true)
+		report.SkipCJpeg = true
+		delete(failed, "cjpeg")
+	}
+
+	var errs []error
+	for _, status := range report.Tools {
+		if err, ok := failed[status.Tool]; ok {
+			errs = append(errs, fmt.Errorf("%s: %w", status.Tool, err))
+		}
+	}
+
+	return report, errors.Join(errs...)
+}
+
+// skipCJpeg reports whether the runner asks CJpeg to work without cjpeg.
+func (c *CJpeg) skipCJpeg() bool {
+	r, ok := c.Runner.(*EmbeddedRunner)
+	return ok && r.skipCJpeg.Load()
+}
+
+// checkSkipCJpeg returns an error if options that can't be followed without cjpeg are set.
+// Outputs are always optimized, and made baseline by jpegtran with Baseline.
+func (c *CJpeg) checkSkipCJpeg() error {
+	var unsupported []string
+	if len(c.quantTables) > 0 {
+		unsupported = append(unsupported, "QuantTables")
+	}
+
+	if c.arithmetic {
+		unsupported = append(unsupported, "Arithmetic")
+	}
+
+	if c.revert {
+		unsupported = append(unsupported, "Revert")
+	}
+
+	if len(unsupported) > 0 {
+		return fmt.Errorf("%s not supported with SkipCJpeg", strings.Join(unsupported, ", "))
+	}
+
+	return nil
+}
+
+// subsampleRatio returns the chroma subsampling set with Subsample.
+func (c *CJpeg) subsampleRatio() image.YCbCrSubsampleRatio {
+	for _, ratio := range []image.YCbCrSubsampleRatio{
+		image.YCbCrSubsampleRatio444,
+		image.YCbCrSubsampleRatio422,
+		image.YCbCrSubsampleRatio440,
+		image.YCbCrSubsampleRatio411,
+		image.YCbCrSubsampleRatio410,
+	} {
+		h, v, _ := samplingFactors(ratio)
+		if c.sample == fmt.Sprintf("%dx%d", h, v) {
+			return ratio
+		}
+	}
+
+	return image.YCbCrSubsampleRatio420
+}
+
+// toYCbCr converts an opaque image to YCbCr planes with ratio, averaging chroma over each subsampled block.
+func toYCbCr(img image.Image, ratio image.YCbCrSubsampleRatio) *image.YCbCr {
+	if ycbcr, ok := img.(*image.YCbCr); ok && ycbcr.SubsampleRatio == ratio {
+		return ycbcr
+	}
+
+	b := img.Bounds()
+	out := image.NewYCbCr(b, ratio)
+	h, v, _ := samplingFactors(ratio)
+	width := b.Dx()
+	cb := make([]int, width*b.Dy())
+	cr := make([]int, width*b.Dy())
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			yy, u, w := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(bl>>8))
+			out.Y[out.YOffset(x, y)] = yy
+			i := (y-b.Min.Y)*width + x - b.Min.X
+			cb[i], cr[i] = int(u), int(w)
+		}
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y += v {
+		for x := b.Min.X; x < b.Max.X; x += h {
+			var sumCb, sumCr, n int
+			for dy := 0; dy < v && y+dy < b.Max.Y; dy++ {
+				for dx := 0; dx < h && x+dx < b.Max.X; dx++ {
+					i := (y+dy-b.Min.Y)*width + x + dx - b.Min.X
+					sumCb += cb[i]
+					sumCr += cr[i]
+					n++
+				}
+			}
+
+			i := out.COffset(x, y)
+			out.Cb[i] = uint8((sumCb + n/2) / n)
+			out.Cr[i] = uint8((sumCr + n/2) / n)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,223 @@
+package mozjpegbin_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelfTest(t *testing.T) {
+	report, err := mozjpegbin.SelfTest()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	if !assert.Len(t, report.Tools, 3) {
+		t.FailNow()
+	}
+
+	for _, status := range report.Tools {
+		assert.Nil(t, status.Err, status.Tool)
+		assert.Contains(t, status.Version, "mozjpeg", status.Tool)
+	}
+	assert.False(t, report.SkipCJpeg)
+}
+
+// loaderRunner fails like a binary whose shared library can't be loaded.
+type loaderRunner struct{}
+
+func (loaderRunner) Run(ctx context.Context, inv *mozjpegbin.Invocation) (*mozjpegbin.RunResult, error) {
+	stderr := inv.Tool + ": error while loading shared libraries: libpng16.so.16: cannot open shared object file: No such file or directory\n"
+	return &mozjpegbin.RunResult{Stderr: []byte(stderr)}, errors.New("exit status 127")
+}
+
+func TestMissingLibraryError(t *testing.T) {
+	_, err := mozjpegbin.NewCJpegWithRunner(loaderRunner{}).Version()
+
+	var missing *mozjpegbin.MissingLibraryError
+	if !assert.True(t, errors.As(err, &missing)) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, "cjpeg", missing.Tool)
+	assert.Equal(t, "libpng16.so.16", missing.Library)
+	assert.EqualError(t, missing.Err, "exit status 127")
+}
+
+// cjpegLoaderRunner fails like loaderRunner for cjpeg, and runs the other tools.
+type cjpegLoaderRunner struct {
+	mozjpegbin.Runner
+}
+
+func (r cjpegLoaderRunner) Run(ctx context.Context, inv *mozjpegbin.Invocation) (*mozjpegbin.RunResult, error) {
+	if inv.Tool == "cjpeg" {
+		return loaderRunner{}.Run(ctx, inv)
+	}
+
+	return r.Runner.Run(ctx, inv)
+}
+
+func TestSelfTestNotEmbedded(t *testing.T) {
+	// SkipCJpeg can only be enabled on an EmbeddedRunner, other runners get the error.
+	prev := mozjpegbin.DefaultRunner()
+	defer mozjpegbin.SetDefaultRunner(prev)
+
+	if !assert.Nil(t, mozjpegbin.SetDefaultRunner(cjpegLoaderRunner{mozjpegbin.NewEmbeddedRunner()})) {
+		t.FailNow()
+	}
+
+	report, err := mozjpegbin.SelfTest()
+	var missing *mozjpegbin.MissingLibraryError
+	if !assert.True(t, errors.As(err, &missing)) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, "cjpeg", missing.Tool)
+	assert.False(t, report.SkipCJpeg)
+	for _, status := range report.Tools[1:] {
+		assert.Nil(t, status.Err, status.Tool)
+	}
+}
+
+func TestSkipCJpeg(t *testing.T) {
+	img := testImage()
+
+	var pngData bytes.Buffer
+	if !assert.Nil(t, png.Encode(&pngData, img)) {
+		t.FailNow()
+	}
+
+	jpegData, err := os.ReadFile("source.jpg")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	inputs := map[string][]byte{
+		"png":  pngData.Bytes(),
+		"jpeg": jpegData,
+		"pnm":  append([]byte("P6\n2 1\n255\n"), 255, 0, 0, 0, 0, 255),
+	}
+
+	for name, input := range inputs {
+		runner := mozjpegbin.NewEmbeddedRunner().SkipCJpeg(true)
+		var out bytes.Buffer
+
+		err := mozjpegbin.NewCJpegWithRunner(runner).
+			Quality(80).
+			Input(bytes.NewReader(input)).
+			Output(&out).
+			Run()
+		if !assert.Nil(t, err, name) {
+			continue
+		}
+
+		decoded, err := jpeg.Decode(&out)
+		if assert.Nil(t, err, name) {
+			src, _, err := image.DecodeConfig(bytes.NewReader(input))
+			if err == nil {
+				assert.Equal(t, image.Rect(0, 0, src.Width, src.Height), decoded.Bounds(), name)
+			}
+		}
+	}
+}
+
+func TestSkipCJpegTarga(t *testing.T) {
+	runner := mozjpegbin.NewEmbeddedRunner().SkipCJpeg(true)
+	targa := make([]byte, 18+3)
+	targa[2], targa[12], targa[14], targa[16] = 2, 1, 1, 24
+
+	err := mozjpegbin.NewCJpegWithRunner(runner).
+		Input(bytes.NewReader(targa)).
+		Output(&bytes.Buffer{}).
+		Run()
+
+	var unsupported *mozjpegbin.UnsupportedFormatError
+	if assert.True(t, errors.As(err, &unsupported)) {
+		assert.Equal(t, "Targa", unsupported.Format)
+	}
+}
+
+func TestSkipCJpegImage(t *testing.T) {
+	runner := mozjpegbin.NewEmbeddedRunner().SkipCJpeg(true)
+	img := image.NewNRGBA(image.Rect(0, 0, 17, 9))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	img.Set(0, 0, color.NRGBA{R: 255, A: 255})
+
+	var out bytes.Buffer
+	err := mozjpegbin.NewCJpegWithRunner(runner).
+		InputImage(img).
+		Output(&out).
+		Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	decoded, err := jpeg.Decode(&out)
+	if assert.Nil(t, err) {
+		assert.Equal(t, img.Bounds(), decoded.Bounds())
+	}
+}
+
+func TestSkipCJpegOptions(t *testing.T) {
+	runner := mozjpegbin.NewEmbeddedRunner().SkipCJpeg(true)
+
+	var out bytes.Buffer
+	err := mozjpegbin.NewCJpegWithRunner(runner).
+		Baseline(true).
+		InputImage(testImage()).
+		Output(&out).
+		Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	info, err := mozjpegbin.Inspect(&out)
+	if assert.Nil(t, err) {
+		assert.False(t, info.Progressive)
+	}
+
+	err = mozjpegbin.NewCJpegWithRunner(runner).
+		Revert(true).
+		QuantTables([64]int{}).
+		InputImage(testImage()).
+		Output(&bytes.Buffer{}).
+		Run()
+	assert.EqualError(t, err, "QuantTables, Revert not supported with SkipCJpeg")
+}
+
+func TestSkipCJpegThumbnail(t *testing.T) {
+	runner := mozjpegbin.NewEmbeddedRunner().SkipCJpeg(true)
+
+	var out bytes.Buffer
+	err := mozjpegbin.NewJpegTranWithRunner(runner).
+		Input(bytes.NewReader(jpegWithMetadata(t))).
+		Output(&out).
+		CopyAll().
+		Thumbnail(mozjpegbin.ThumbnailRegenerate).
+		Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	payload := exifPayload(t, out.Bytes())
+	start := bytes.Index(payload, []byte{0xFF, 0xD8, 0xFF})
+	if !assert.True(t, start > 0) {
+		t.FailNow()
+	}
+
+	info, err := mozjpegbin.Inspect(bytes.NewReader(payload[start:]))
+	if assert.Nil(t, err) {
+		assert.False(t, info.Progressive)
+	}
+}
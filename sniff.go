@@ -0,0 +1,184 @@
+package mozjpegbin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// UnsupportedFormatError is returned when the input of CJpeg is in a format that can't be converted to JPEG.
+type UnsupportedFormatError struct {
+	// Format is the name of the format if it was recognized, like "HEIF", or "unknown".
+	Format string
+}
+
+func (e *UnsupportedFormatError) Error() string {
+	return fmt.Sprintf("unsupported input format: %s", e.Format)
+}
+
+// inputFormat is a format recognized from the first bytes of an input.
+type inputFormat struct {
+	name string
+	// native is set for formats read by cjpeg itself.
+	native bool
+	// decode decodes the format in Go. Formats that aren't native are converted with it before being passed to cjpeg.
+	decode func(io.Reader) (image.Image, error)
+}
+
+var (
+	formatJPEG  = inputFormat{name: "JPEG", native: true, decode: jpeg.Decode}
+	formatPNM   = inputFormat{name: "PNM", native: true, decode: readPNM}
+	formatBMP   = inputFormat{name: "BMP", native: true, decode: bmp.Decode}
+	formatTarga = inputFormat{name: "Targa", native: true}
+	formatPNG   = inputFormat{name: "PNG", decode: png.Decode}
+	formatGIF   = inputFormat{name: "GIF", decode: gif.Decode}
+	formatWebP  = inputFormat{name: "WebP", decode: webp.Decode}
+	formatTIFF  = inputFormat{name: "TIFF", decode: tiff.Decode}
+)
+
+// sniffLength is the number of bytes needed by sniffFormat.
+const sniffLength = 18
+
+// sniffFormat recognizes the format of an input from its first bytes.
+// JPEG, PPM, PGM, BMP and Targa files are read by cjpeg itself, PNG, GIF, WebP and TIFF files are converted in Go.
+// PNG files are converted too, as cjpeg's PNG support depends on a shared library.
+func sniffFormat(head []byte) (inputFormat, error) {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, markerSOI, 0xFF}):
+		return formatJPEG, nil
+	case bytes.HasPrefix(head, []byte("BM")):
+		return formatBMP, nil
+	case len(head) >= 2 && head[0] == 'P' && (head[1] == '2' || head[1] == '3' || head[1] == '5' || head[1] == '6'):
+		return formatPNM, nil
+	case bytes.HasPrefix(head, pngSignature):
+		return formatPNG, nil
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return formatGIF, nil
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WEBP":
+		return formatWebP, nil
+	case bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")):
+		return formatTIFF, nil
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		switch string(head[8:12]) {
+		case "avif", "avis":
+			return inputFormat{}, &UnsupportedFormatError{Format: "AVIF"}
+		case "heic", "heix", "mif1", "msf1":
+			return inputFormat{}, &UnsupportedFormatError{Format: "HEIF"}
+		}
+	case isTarga(head):
+		return formatTarga, nil
+	}
+
+	return inputFormat{}, &UnsupportedFormatError{Format: "unknown"}
+}
+
+// isTarga reports whether head looks like the header of a Targa file, which has no magic number.
+func isTarga(head []byte) bool {
+	if len(head) < sniffLength {
+		return false
+	}
+
+	colorMapType, imageType, depth := head[1], head[2], head[16]
+	switch imageType {
+	case 1, 9:
+		return colorMapType == 1 && depth == 8
+	case 2, 10:
+		return colorMapType == 0 && (depth == 16 || depth == 24 || depth == 32)
+	case 3, 11:
+		return colorMapType == 0 && depth == 8
+	default:
+		return false
+	}
+}
+
+// sniffReader recognizes the format of the data read from r.
+// The returned reader yields all the data of r, including the bytes read to recognize it.
+func sniffReader(r io.Reader) (inputFormat, io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(sniffLength)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return inputFormat{}, nil, err
+	}
+
+	format, err := sniffFormat(head)
+	return format, br, err
+}
+
+// sniffFile recognizes the format of a file.
+func sniffFile(name string) (inputFormat, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return inputFormat{}, err
+	}
+
+	defer f.Close()
+	format, _, err := sniffReader(f)
+	return format, err
+}
+
+// convertInput decodes data with format, resizes it and turns it into an input cjpeg reads without loss.
+func (c *CJpeg) convertInput(ctx context.Context, format inputFormat, data []byte) (*cjpegInput, error) {
+	img, err := c.decodeInput(ctx, format, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.imageInput(img, data)
+}
+
+// decodeInput decodes data with format, resizes it and composites it over the background.
+func (c *CJpeg) decodeInput(ctx context.Context, format inputFormat, data []byte) (image.Image, error) {
+	if format.decode == nil {
+		return nil, &UnsupportedFormatError{Format: format.name}
+	}
+
+	var img image.Image
+	var sr image.Rectangle
+	var width, height int
+	var resize bool
+
+	if format.name == formatJPEG.name && c.resizing() {
+		img, sr, width, height, resize = c.decodeJPEGScaled(ctx, data)
+	}
+
+	if img == nil {
+		var err error
+		if img, err = format.decode(bytes.NewReader(data)); err != nil {
+			return nil, fmt.Errorf("failed to decode %s input: %v", format.name, err)
+		}
+
+		sr, width, height, resize = c.resize.plan(img.Bounds())
+	}
+
+	if resize {
+		return resample(img, c.background, sr, width, height, c.resize.Filter), nil
+	}
+
+	return flatten(img, c.background), nil
+}
+
+// imageInput turns an opaque image decoded from source into an input cjpeg reads without loss.
+// If cjpeg is skipped, the image is encoded from YCbCr planes instead.
+func (c *CJpeg) imageInput(img image.Image, source []byte) (*cjpegInput, error) {
+	if c.skipCJpeg() {
+		return &cjpegInput{ycbcr: toYCbCr(img, c.subsampleRatio()), image: img, source: source}, nil
+	}
+
+	var pnm bytes.Buffer
+	if err := writePNM(&pnm, img); err != nil {
+		return nil, err
+	}
+
+	return &cjpegInput{data: pnm.Bytes(), image: img, source: source}, nil
+}
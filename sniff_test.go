@@ -0,0 +1,162 @@
+package mozjpegbin_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/tiff"
+)
+
+func sniffTestImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 48, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 48; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x * 5), G: uint8(y * 8), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func TestCJpegInputFormats(t *testing.T) {
+	img := sniffTestImage()
+	encoders := map[string]func(*bytes.Buffer) error{
+		"png":  func(b *bytes.Buffer) error { return png.Encode(b, img) },
+		"gif":  func(b *bytes.Buffer) error { return gif.Encode(b, img, nil) },
+		"tiff": func(b *bytes.Buffer) error { return tiff.Encode(b, img, nil) },
+	}
+
+	for name, encode := range encoders {
+		t.Run(name, func(t *testing.T) {
+			var in bytes.Buffer
+			if !assert.Nil(t, encode(&in)) {
+				t.FailNow()
+			}
+
+			file := filepath.Join(t.TempDir(), "input."+name)
+			if !assert.Nil(t, os.WriteFile(file, in.Bytes(), 0666)) {
+				t.FailNow()
+			}
+
+			c, err := mozjpegbin.NewCJpeg()
+			if !assert.Nil(t, err) {
+				t.FailNow()
+			}
+
+			for _, input := range []func(){
+				func() { c.Input(bytes.NewReader(in.Bytes())) },
+				func() { c.InputFile(file) },
+			} {
+				input()
+
+				var out bytes.Buffer
+				if !assert.Nil(t, c.Output(&out).Run()) {
+					t.FailNow()
+				}
+
+				decoded, err := mozjpegbin.Decode(&out)
+				if !assert.Nil(t, err) {
+					t.FailNow()
+				}
+
+				assert.Equal(t, img.Bounds(), decoded.Bounds())
+			}
+		})
+	}
+}
+
+func TestCJpegInputWebP(t *testing.T) {
+	// A 1x1 lossless WebP image.
+	webp := []byte("RIFF\x1a\x00\x00\x00WEBPVP8L\x0d\x00\x00\x00\x2f\x00\x00\x00\x10\x07\x10\x11\x11\x88\x88\xfe\x07\x00")
+
+	c, err := mozjpegbin.NewCJpeg()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var out bytes.Buffer
+	if !assert.Nil(t, c.Input(bytes.NewReader(webp)).Output(&out).Run()) {
+		t.FailNow()
+	}
+
+	info, err := mozjpegbin.Inspect(&out)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, 1, info.Width)
+	assert.Equal(t, 1, info.Height)
+}
+
+func TestCJpegUnsupportedFormat(t *testing.T) {
+	c, err := mozjpegbin.NewCJpeg()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	heif := []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic")
+	err = c.Input(bytes.NewReader(heif)).Output(&bytes.Buffer{}).Run()
+
+	var formatErr *mozjpegbin.UnsupportedFormatError
+	if assert.True(t, errors.As(err, &formatErr)) {
+		assert.Equal(t, "HEIF", formatErr.Format)
+	}
+
+	err = c.Input(bytes.NewReader([]byte("hello, world"))).Output(&bytes.Buffer{}).Run()
+	if assert.True(t, errors.As(err, &formatErr)) {
+		assert.Equal(t, "unknown", formatErr.Format)
+	}
+}
+
+func TestCJpegResizePNM(t *testing.T) {
+	img := sniffTestImage()
+
+	// ASCII and 16 bit rasters are decoded in Go to be resized.
+	inputs := map[string]*bytes.Buffer{
+		"P3": bytes.NewBufferString("P3\n# ascii\n48 32\n15\n"),
+		"P6": bytes.NewBufferString("P6\n48 32\n65535\n"),
+	}
+
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 48; x++ {
+			c := img.RGBAAt(x, y)
+			fmt.Fprintf(inputs["P3"], "%d %d %d\n", c.R/17, c.G/17, c.B/17)
+			inputs["P6"].Write([]byte{c.R, c.R, c.G, c.G, c.B, c.B})
+		}
+	}
+
+	for name, in := range inputs {
+		c, err := mozjpegbin.NewCJpeg()
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+
+		var out bytes.Buffer
+		err = c.Resize(24, 0, nil).Input(in).Output(&out).Run()
+		if !assert.Nil(t, err, name) {
+			continue
+		}
+
+		decoded, err := mozjpegbin.Decode(&out)
+		if !assert.Nil(t, err, name) {
+			continue
+		}
+
+		assert.Equal(t, image.Rect(0, 0, 24, 16), decoded.Bounds(), name)
+
+		r, g, b, _ := decoded.At(12, 8).RGBA()
+		want := img.RGBAAt(24, 16)
+		assert.InDelta(t, want.R, r>>8, 24, name)
+		assert.InDelta(t, want.G, g>>8, 24, name)
+		assert.InDelta(t, want.B, b>>8, 24, name)
+	}
+}
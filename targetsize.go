@@ -0,0 +1,77 @@
+package mozjpegbin
+
+import (
+	"errors"
+	"fmt"
+)
+
+// maxTargetSizeTrials bounds the number of encodes done to reach a target size.
+// Bisecting the whole quality range needs 1 + ceil(log2(100)) trials.
+const maxTargetSizeTrials = 8
+
+// ErrTargetSizeUnreachable is returned when even the lowest quality doesn't fit into the requested size.
+var ErrTargetSizeUnreachable = errors.New("target size is unreachable")
+
+// TargetSize makes Run pick the highest quality whose output is at most maxBytes bytes long,
+// including the ICC profile and the metadata kept.
+// The quality set with Quality is the highest one tried, 100 if it wasn't set.
+// Run bisects the quality range, encoding at most 8 times; the input is read or serialized only once.
+// Result reports the chosen quality. If no quality fits, Run returns an error wrapping ErrTargetSizeUnreachable.
+// A maxBytes of 0 disables the search. TargetQuality called before will be ignored.
+func (c *CJpeg) TargetSize(maxBytes int) *CJpeg {
+	c.target = nil
+	c.targetSize = max(maxBytes, 0)
+	return c
+}
+
+// maxQuality returns the upper bound of quality searches.
+func (c *CJpeg) maxQuality() int {
+	if c.quality < 0 {
+		return 100
+	}
+
+	return c.quality
+}
+
+// searchQuality finds the highest quality between lo and hi for which encode returns at most maxBytes bytes.
+// It returns the output, the quality and the number of encodes done.
+func searchQuality(maxBytes, lo, hi int, encode func(quality int) ([]byte, error)) ([]byte, int, int, error) {
+	data, err := encode(hi)
+	if err != nil {
+		return nil, 0, 1, err
+	}
+
+	if len(data) <= maxBytes {
+		return data, hi, 1, nil
+	}
+
+	trials := 1
+	smallest := len(data)
+	var best []byte
+	bestQuality := -1
+
+	for hi--; lo <= hi && trials < maxTargetSizeTrials; {
+		mid := (lo + hi) / 2
+
+		data, err := encode(mid)
+		trials++
+
+		if err != nil {
+			return nil, 0, trials, err
+		}
+
+		if len(data) <= maxBytes {
+			best, bestQuality = data, mid
+			lo = mid + 1
+		} else {
+			smallest = min(smallest, len(data))
+			hi = mid - 1
+		}
+	}
+
+	if best == nil {
+		return nil, 0, trials, fmt.Errorf("%w: smallest output is %d bytes, %d requested", ErrTargetSizeUnreachable, smallest, maxBytes)
+	}
+
+	return best, bestQuality, trials, nil
+}
@@ -0,0 +1,94 @@
+package mozjpegbin_test
+
+import (
+	"bytes"
+	"errors"
+	"image/jpeg"
+	"os"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCJpegTargetSize(t *testing.T) {
+	c, err := mozjpegbin.NewCJpeg()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	f, err := os.Open("source.jpg")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer f.Close()
+
+	const maxBytes = 60000
+	var out bytes.Buffer
+	err = c.Input(f).Output(&out).TargetSize(maxBytes).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	result := c.Result()
+	assert.LessOrEqual(t, out.Len(), maxBytes)
+	assert.Equal(t, out.Len(), result.Size)
+	assert.Less(t, result.Quality, 100)
+	assert.LessOrEqual(t, result.Trials, 8)
+
+	_, err = jpeg.Decode(&out)
+	assert.Nil(t, err)
+}
+
+func TestCJpegTargetSizeUnreachable(t *testing.T) {
+	c, err := mozjpegbin.NewCJpeg()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	err = c.InputFile("source.jpg").Output(&bytes.Buffer{}).TargetSize(100).Run()
+	assert.True(t, errors.Is(err, mozjpegbin.ErrTargetSizeUnreachable))
+	assert.Nil(t, c.Result())
+}
+
+func TestEncodeMaxBytes(t *testing.T) {
+	f, err := os.Open("source.jpg")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer f.Close()
+
+	img, err := jpeg.Decode(f)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var out bytes.Buffer
+	result, err := mozjpegbin.EncodeWithResult(&out, img, &mozjpegbin.Options{Quality: 90, MaxBytes: 80000})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.LessOrEqual(t, out.Len(), 80000)
+	assert.Equal(t, out.Len(), result.Size)
+	assert.LessOrEqual(t, result.Quality, 90)
+}
+
+func TestCJpegTargetSizeICC(t *testing.T) {
+	c, err := mozjpegbin.NewCJpeg()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	const maxBytes = 60000
+	var out bytes.Buffer
+	err = c.InputFile("source.jpg").Output(&out).ICCProfile(testProfile()[:40000]).TargetSize(maxBytes).Run()
+	if err != nil {
+		assert.True(t, errors.Is(err, mozjpegbin.ErrTargetSizeUnreachable), err)
+		return
+	}
+
+	assert.LessOrEqual(t, out.Len(), maxBytes)
+	assert.Equal(t, out.Len(), c.Result().Size)
+	assert.Equal(t, testProfile()[:40000], readICC(t, out.Bytes()))
+}
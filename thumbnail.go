@@ -0,0 +1,166 @@
+package mozjpegbin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+)
+
+// ThumbnailMode tells what JpegTran does with the EXIF thumbnail of the files it transforms.
+type ThumbnailMode int
+
+const (
+	// ThumbnailCopy copies the thumbnail unchanged. It's the default.
+	ThumbnailCopy ThumbnailMode = iota
+	// ThumbnailRegenerate replaces the thumbnail with one made from the output.
+	ThumbnailRegenerate
+	// ThumbnailRemove removes the thumbnail.
+	ThumbnailRemove
+)
+
+// Largest thumbnail size, as recommended by the EXIF specification.
+const (
+	thumbnailWidth  = 160
+	thumbnailHeight = 120
+)
+
+const (
+	exifTagImageWidth      = 0x0100
+	exifTagImageLength     = 0x0101
+	exifTagPixelXDimension = 0xA002
+	exifTagPixelYDimension = 0xA003
+)
+
+// updateExif rewrites the EXIF segment of data, if any, following mode:
+// the dimensions recorded in it are set to those of data and the thumbnail is regenerated or removed.
+func updateExif(ctx context.Context, runner Runner, data []byte, mode ThumbnailMode) ([]byte, error) {
+	segments, rest, err := splitHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	index := -1
+	for i, s := range segments {
+		if s.marker == markerAPP1 && s.hasPrefix(exifPrefix) {
+			index = i
+			break
+		}
+	}
+
+	if index < 0 {
+		return data, nil
+	}
+
+	exif, err := parseExif(segments[index].data)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := inspect(data)
+	if err != nil {
+		return nil, err
+	}
+
+	exif.ifd0 = setDimension(exif.ifd0, exifTagImageWidth, info.Width, exif.order)
+	exif.ifd0 = setDimension(exif.ifd0, exifTagImageLength, info.Height, exif.order)
+	exif.exif = setDimension(exif.exif, exifTagPixelXDimension, info.Width, exif.order)
+	exif.exif = setDimension(exif.exif, exifTagPixelYDimension, info.Height, exif.order)
+
+	switch {
+	case mode == ThumbnailRemove:
+		exif.ifd1 = nil
+		exif.thumbnail = nil
+	case mode == ThumbnailRegenerate && exif.thumbnail != nil:
+		thumbnail, err := makeThumbnail(ctx, runner, data, info.Width, info.Height)
+		if err != nil {
+			return nil, err
+		}
+
+		exif.thumbnail = thumbnail
+	}
+
+	encoded := exif.encode()
+	if len(encoded)+2 > 0xFFFF {
+		// The regenerated thumbnail doesn't fit, keep the rest of the data.
+		exif.ifd1 = nil
+		exif.thumbnail = nil
+		encoded = exif.encode()
+	}
+
+	segments[index] = segment{marker: markerAPP1, data: encoded}
+	return joinHeader(segments, rest), nil
+}
+
+// setDimension sets the value of tag if it's present in entries. SHORT values are widened to LONG when needed.
+func setDimension(entries []tiffEntry, tag uint16, value int, order byteOrder) []tiffEntry {
+	for i, entry := range entries {
+		if entry.tag != tag {
+			continue
+		}
+
+		if entry.typ == 3 && value <= 0xFFFF {
+			entries[i].value = order.AppendUint16(nil, uint16(value))
+		} else {
+			entries[i] = tiffEntry{tag: tag, typ: 4, count: 1, value: order.AppendUint32(nil, uint32(value))}
+		}
+	}
+
+	return entries
+}
+
+// makeThumbnail makes a baseline JPEG thumbnail fitting into 160x120 pixels from data.
+// djpeg scales data down in the DCT domain, the rest of the way is done in Go, in linear light.
+func makeThumbnail(ctx context.Context, runner Runner, data []byte, width, height int) ([]byte, error) {
+	scale := 1
+	for scale < 8 && (ceilDiv(width*(scale+1), 8) <= thumbnailWidth && ceilDiv(height*(scale+1), 8) <= thumbnailHeight) {
+		scale++
+	}
+
+	var scaled bytes.Buffer
+	_, err := runTool(ctx, runner, &Invocation{
+		Tool:   "djpeg",
+		Args:   []string{"-scale", fmt.Sprintf("%d/8", scale), "-pnm"},
+		Stdin:  bytes.NewReader(data),
+		Stdout: &scaled,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := readPNM(&scaled)
+	if err != nil {
+		return nil, err
+	}
+
+	img = resizeImage(img, nil, &Resize{Width: thumbnailWidth, Height: thumbnailHeight})
+
+	var pnm, out bytes.Buffer
+	if err := writePNM(&pnm, img); err != nil {
+		return nil, err
+	}
+
+	// CJpeg follows SkipCJpeg.
+	err = NewCJpegWithRunner(runner).Quality(75).Baseline(true).Input(&pnm).Output(&out).RunContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Thumbnails are stored without metadata.
+	segments, rest, err := splitHeader(out.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []segment
+	for _, s := range segments {
+		if !s.isAPP() && s.marker != markerCOM {
+			kept = append(kept, s)
+		}
+	}
+
+	return joinHeader(kept, rest), nil
+}
+
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
@@ -0,0 +1,81 @@
+package mozjpegbin_test
+
+import (
+	"bytes"
+	"image/jpeg"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/stretchr/testify/assert"
+)
+
+// exifPayload returns the payload of the EXIF segment of data.
+func exifPayload(t *testing.T, data []byte) []byte {
+	info, err := mozjpegbin.Inspect(bytes.NewReader(data))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	for _, m := range info.Markers {
+		if m.Identifier == "Exif" {
+			return data[m.Offset+4 : m.Offset+4+m.Size]
+		}
+	}
+
+	t.Fatal("no exif segment")
+	return nil
+}
+
+func TestJpegTranThumbnailRegenerate(t *testing.T) {
+	c, err := mozjpegbin.NewJpegTran()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var out bytes.Buffer
+	err = c.Input(bytes.NewReader(jpegWithMetadata(t))).Output(&out).
+		CopyAll().Crop(0, 0, 320, 240).Thumbnail(mozjpegbin.ThumbnailRegenerate).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	payload := exifPayload(t, out.Bytes())
+	assert.False(t, bytes.Contains(payload, []byte("THUMBNAIL-DATA")))
+	assert.True(t, bytes.Contains(payload, []byte("Jane Doe")))
+
+	// PixelXDimension and PixelYDimension are updated.
+	assert.True(t, bytes.Contains(payload, []byte("\xA0\x02\x00\x04\x00\x00\x00\x01\x00\x00\x01\x40")))
+	assert.True(t, bytes.Contains(payload, []byte("\xA0\x03\x00\x04\x00\x00\x00\x01\x00\x00\x00\xF0")))
+
+	start := bytes.Index(payload, []byte{0xFF, 0xD8, 0xFF})
+	if !assert.True(t, start > 0) {
+		t.FailNow()
+	}
+
+	thumbnail, err := jpeg.Decode(bytes.NewReader(payload[start:]))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, 160, thumbnail.Bounds().Dx())
+	assert.Equal(t, 120, thumbnail.Bounds().Dy())
+}
+
+func TestJpegTranThumbnailRemove(t *testing.T) {
+	c, err := mozjpegbin.NewJpegTran()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var out bytes.Buffer
+	err = c.Input(bytes.NewReader(jpegWithMetadata(t))).Output(&out).
+		CopyAll().Thumbnail(mozjpegbin.ThumbnailRemove).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	payload := exifPayload(t, out.Bytes())
+	assert.False(t, bytes.Contains(payload, []byte("THUMBNAIL-DATA")))
+	assert.True(t, bytes.Contains(payload, []byte("GPS-SECRET")))
+	assert.True(t, bytes.Contains(out.Bytes(), []byte("COMMENT-DATA")))
+}
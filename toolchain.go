@@ -0,0 +1,269 @@
+package mozjpegbin
+
+import (
+	"bytes"
+	"context"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// SIMD tells whether a tool was built with SIMD extensions.
+type SIMD int
+
+const (
+	// SIMDUnknown is reported when the binary of a tool can't be read, like with WasiRunner,
+	// or when it links shared libraries, which may hold libjpeg and its SIMD extensions.
+	SIMDUnknown SIMD = iota
+	SIMDEnabled
+	SIMDDisabled
+)
+
+func (s SIMD) String() string {
+	switch s {
+	case SIMDEnabled:
+		return "enabled"
+	case SIMDDisabled:
+		return "disabled"
+	default:
+		return "unknown"
+	}
+}
+
+// Tool describes a mozjpeg tool as found by ProbeToolchain.
+type Tool struct {
+	Name string
+	// Version is the version line printed by the tool, like "mozjpeg version 3.3.1 (build 20190710)".
+	Version string
+	// Mozjpeg is the mozjpeg version, like "3.3.1". It's empty if the tool isn't from mozjpeg.
+	Mozjpeg string
+	// LibjpegTurbo is the libjpeg-turbo version the tool is built on. mozjpeg doesn't print it,
+	// so for mozjpeg only the release series is known, like "1.5".
+	LibjpegTurbo string
+	// IJG is the version of the libjpeg API emulated by the tool, like "8d".
+	IJG string
+	// BuildDate is the build stamp printed by the tool, like "20190710".
+	BuildDate string
+	SIMD      SIMD
+	// Flags are the switches listed by the tool's usage, like "-crop".
+	Flags map[string]bool
+}
+
+// Supports reports whether the tool accepts flag, like "-tune-ms-ssim".
+func (t *Tool) Supports(flag string) bool {
+	return t != nil && t.Flags[flag]
+}
+
+// Toolchain describes the cjpeg, jpegtran and djpeg run by a Runner.
+type Toolchain struct {
+	CJpeg    *Tool
+	JpegTran *Tool
+	DJpeg    *Tool
+}
+
+// Tool returns the description of the tool named name, or nil.
+func (t *Toolchain) Tool(name string) *Tool {
+	switch name {
+	case "cjpeg":
+		return t.CJpeg
+	case "jpegtran":
+		return t.JpegTran
+	case "djpeg":
+		return t.DJpeg
+	default:
+		return nil
+	}
+}
+
+// Supports reports whether tool accepts flag.
+func (t *Toolchain) Supports(tool, flag string) bool {
+	return t.Tool(tool).Supports(flag)
+}
+
+// check returns an UnsupportedFeatureError for the first of flags the tool doesn't accept.
+func (t *Toolchain) check(tool string, flags ...string) error {
+	for _, flag := range flags {
+		if !t.Supports(tool, flag) {
+			info := t.Tool(tool)
+			version := ""
+			if info != nil {
+				version = info.Version
+			}
+
+			return &UnsupportedFeatureError{Tool: tool, Flag: flag, Version: version}
+		}
+	}
+
+	return nil
+}
+
+// UnsupportedFeatureError is returned when an option needs a switch that the selected binary doesn't have.
+type UnsupportedFeatureError struct {
+	Tool string
+	Flag string
+	// Version is the version line of the tool.
+	Version string
+}
+
+func (e *UnsupportedFeatureError) Error() string {
+	if e.Version == "" {
+		return fmt.Sprintf("%s doesn't support %s", e.Tool, e.Flag)
+	}
+
+	return fmt.Sprintf("%s (%s) doesn't support %s", e.Tool, e.Version, e.Flag)
+}
+
+// binarySource is implemented by runners able to return the executable of a tool.
+type binarySource interface {
+	binary(tool string) ([]byte, error)
+}
+
+var (
+	versionLine   = regexp.MustCompile(`(?m)^(mozjpeg|libjpeg-turbo) version (\S+)(?: \(build (\d+)\))?`)
+	ijgLine       = regexp.MustCompile(`Independent JPEG Group's software, version (\S+)`)
+	usageSwitch   = regexp.MustCompile(`(?m)^\s{1,4}(-[a-z][a-z0-9-]*)`)
+	turboVersions = map[string]string{
+		"3.0": "1.4",
+		"3.1": "1.4",
+		"3.2": "1.5",
+		"3.3": "1.5",
+		"4.0": "2.0",
+		"4.1": "2.1",
+	}
+)
+
+// ProbeToolchain runs cjpeg, jpegtran and djpeg with r to find their versions and supported switches.
+// A tool that can't run makes ProbeToolchain fail.
+func ProbeToolchain(r Runner) (*Toolchain, error) {
+	return ProbeToolchainContext(context.Background(), r)
+}
+
+// ProbeToolchainContext is ProbeToolchain stopping the tools when ctx is done.
+func ProbeToolchainContext(ctx context.Context, r Runner) (*Toolchain, error) {
+	t := &Toolchain{}
+	var err error
+
+	if t.CJpeg, err = probeTool(ctx, r, "cjpeg"); err != nil {
+		return nil, err
+	}
+
+	if t.JpegTran, err = probeTool(ctx, r, "jpegtran"); err != nil {
+		return nil, err
+	}
+
+	if t.DJpeg, err = probeTool(ctx, r, "djpeg"); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func probeTool(ctx context.Context, r Runner, name string) (*Tool, error) {
+	// -verbose prints the banner before the usage, which is printed for the unknown -help switch.
+	// The tool exits with an error then, which is expected.
+	res, err := r.Run(ctx, &Invocation{Tool: name, Args: []string{"-verbose", "-help"}, Stdin: bytes.NewReader(nil)})
+	if res == nil || !bytes.Contains(res.Stderr, []byte("usage:")) {
+		if err == nil {
+			err = errors.New("no usage printed")
+		}
+
+		if res != nil {
+			if library, ok := missingLibrary(res.Stderr); ok {
+				return nil, &MissingLibraryError{Tool: name, Library: library, Err: err}
+			}
+		}
+
+		return nil, fmt.Errorf("failed to probe %s: %w", name, err)
+	}
+
+	info := parseTool(name, res.Stderr)
+
+	if src, ok := r.(binarySource); ok {
+		if bin, err := src.binary(name); err == nil {
+			info.SIMD = detectSIMD(bin)
+		}
+	}
+
+	return info, nil
+}
+
+// parseTool reads the banner and the usage printed by a tool.
+func parseTool(name string, out []byte) *Tool {
+	info := &Tool{Name: name, Flags: map[string]bool{}}
+
+	if m := versionLine.FindSubmatch(out); m != nil {
+		info.Version = strings.TrimSpace(string(m[0]))
+		info.BuildDate = string(m[3])
+
+		if string(m[1]) == "mozjpeg" {
+			info.Mozjpeg = string(m[2])
+			if parts := strings.SplitN(info.Mozjpeg, ".", 3); len(parts) >= 2 {
+				info.LibjpegTurbo = turboVersions[parts[0]+"."+parts[1]]
+			}
+		} else {
+			info.LibjpegTurbo = string(m[2])
+		}
+	}
+
+	if m := ijgLine.FindSubmatch(out); m != nil {
+		info.IJG = string(m[1])
+	}
+
+	if i := bytes.Index(out, []byte("usage:")); i >= 0 {
+		for _, m := range usageSwitch.FindAllSubmatch(out[i:], -1) {
+			info.Flags[string(m[1])] = true
+		}
+	}
+
+	return info
+}
+
+// detectSIMD looks for the environment variables read by the SIMD dispatcher of libjpeg-turbo,
+// like JSIMD_FORCESSE2, which are only compiled in when SIMD extensions are.
+// They're in the shared library when libjpeg is linked dynamically, like for system packages.
+func detectSIMD(bin []byte) SIMD {
+	if bytes.Contains(bin, []byte("JSIMD_FORCE")) {
+		return SIMDEnabled
+	}
+
+	if linksLibraries(bin) {
+		return SIMDUnknown
+	}
+
+	return SIMDDisabled
+}
+
+// linksLibraries reports whether the executable bin links shared libraries, or can't be parsed.
+func linksLibraries(bin []byte) bool {
+	r := bytes.NewReader(bin)
+	var libs []string
+	var err error
+
+	if f, e := elf.NewFile(r); e == nil {
+		libs, err = f.ImportedLibraries()
+	} else if f, e := macho.NewFile(r); e == nil {
+		libs, err = f.ImportedLibraries()
+	} else if f, e := pe.NewFile(r); e == nil {
+		libs, err = f.ImportedLibraries()
+	} else {
+		return true
+	}
+
+	return err != nil || len(libs) > 0
+}
+
+// switches returns the switches of args, leaving out their values.
+func switches(args []string) []string {
+	var flags []string
+	for _, arg := range args {
+		if len(arg) > 1 && arg[0] == '-' && arg[1] >= 'a' && arg[1] <= 'z' {
+			flags = append(flags, arg)
+		}
+	}
+
+	return flags
+}
@@ -0,0 +1,122 @@
+package mozjpegbin_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbeToolchain(t *testing.T) {
+	tc, err := mozjpegbin.ProbeToolchain(mozjpegbin.NewEmbeddedRunner())
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	for _, tool := range []*mozjpegbin.Tool{tc.CJpeg, tc.JpegTran, tc.DJpeg} {
+		assert.Equal(t, "mozjpeg version 3.3.1 (build 20190710)", tool.Version, tool.Name)
+		assert.Equal(t, "3.3.1", tool.Mozjpeg, tool.Name)
+		assert.Equal(t, "1.5", tool.LibjpegTurbo, tool.Name)
+		assert.Equal(t, "20190710", tool.BuildDate, tool.Name)
+		assert.Equal(t, "8d", tool.IJG, tool.Name)
+		if runtime.GOOS == "linux" {
+			assert.Equal(t, mozjpegbin.SIMDEnabled, tool.SIMD, tool.Name)
+		}
+	}
+
+	assert.True(t, tc.Supports("cjpeg", "-tune-ms-ssim"))
+	assert.True(t, tc.Supports("cjpeg", "-dc-scan-opt"))
+	assert.False(t, tc.Supports("cjpeg", "-memsrc"))
+	assert.True(t, tc.Supports("jpegtran", "-crop"))
+	assert.True(t, tc.Supports("jpegtran", "-perfect"))
+	assert.False(t, tc.Supports("jpegtran", "-quality"))
+	assert.True(t, tc.Supports("djpeg", "-scale"))
+}
+
+func TestProbeToolchainSharedLibjpeg(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("embedded tools are only checked on Linux")
+	}
+
+	// Without the SIMD dispatcher, a dynamically linked tool may still get it from a shared libjpeg.
+	r := mozjpegbin.NewExecRunner()
+	for _, tool := range []string{"cjpeg", "jpegtran", "djpeg"} {
+		bin, err := os.ReadFile(filepath.Join("bin", "linux", tool))
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+
+		path := filepath.Join(t.TempDir(), tool)
+		bin = bytes.ReplaceAll(bin, []byte("JSIMD_FORCE"), []byte("JSIMD_XXXXX"))
+		if !assert.Nil(t, os.WriteFile(path, bin, 0o755)) {
+			t.FailNow()
+		}
+
+		r.Path(tool, path)
+	}
+
+	tc, err := mozjpegbin.ProbeToolchain(r)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	for _, tool := range []*mozjpegbin.Tool{tc.CJpeg, tc.JpegTran, tc.DJpeg} {
+		assert.Equal(t, mozjpegbin.SIMDUnknown, tool.SIMD, tool.Name)
+	}
+}
+
+func TestCJpegToolchain(t *testing.T) {
+	tc := &mozjpegbin.Toolchain{
+		CJpeg: &mozjpegbin.Tool{
+			Name:    "cjpeg",
+			Version: "libjpeg-turbo version 1.2.0 (build 20120101)",
+			Flags:   map[string]bool{"-quality": true, "-optimize": true},
+		},
+	}
+
+	r := &fakeRunner{output: []byte("jpeg")}
+	c := mozjpegbin.NewCJpegWithRunner(r).Toolchain(tc).Quality(80).Optimize(true)
+	assert.Nil(t, c.Err())
+
+	c.Arithmetic(true)
+
+	var unsupported *mozjpegbin.UnsupportedFeatureError
+	if assert.True(t, errors.As(c.Err(), &unsupported)) {
+		assert.Equal(t, "cjpeg", unsupported.Tool)
+		assert.Equal(t, "-arithmetic", unsupported.Flag)
+		assert.Equal(t, "cjpeg (libjpeg-turbo version 1.2.0 (build 20120101)) doesn't support -arithmetic", unsupported.Error())
+	}
+
+	err := c.InputFile("source.jpg").OutputFile("target.jpg").Run()
+	assert.Equal(t, c.Err(), err)
+	assert.Empty(t, r.invocations)
+
+	c.Arithmetic(false)
+	assert.Nil(t, c.Err())
+}
+
+func TestJpegTranToolchain(t *testing.T) {
+	tc := &mozjpegbin.Toolchain{
+		JpegTran: &mozjpegbin.Tool{
+			Name:  "jpegtran",
+			Flags: map[string]bool{"-optimize": true, "-copy": true},
+		},
+	}
+
+	r := &fakeRunner{}
+	c := mozjpegbin.NewJpegTranWithRunner(r).Toolchain(tc)
+	assert.Nil(t, c.Err())
+
+	err := c.Crop(0, 0, 8, 8).InputFile("source.jpg").OutputFile("target.jpg").Run()
+
+	var unsupported *mozjpegbin.UnsupportedFeatureError
+	if assert.True(t, errors.As(err, &unsupported)) {
+		assert.Equal(t, "-crop", unsupported.Flag)
+	}
+	assert.Empty(t, r.invocations)
+}
@@ -0,0 +1,221 @@
+package mozjpegbin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Variant is a rendition produced by Variants.
+type Variant struct {
+	// Width is the width of the rendition. Images narrower than Width aren't enlarged.
+	Width int
+	// Quality is the quality of the rendition. When 0, the settings of the CJpeg Variants was called on are used.
+	Quality uint
+}
+
+// Rendition is the output of a Variant.
+type Rendition struct {
+	Variant Variant
+	Width   int
+	Height  int
+	// Quality is the quality the rendition was encoded with.
+	Quality int
+	// Size is the length of Data.
+	Size int
+	Data []byte
+	// Scans are the scans of Data. See EncodeResult.
+	Scans []ScanInfo
+}
+
+// VariantsResult describes the outcome of Variants.
+type VariantsResult struct {
+	// Renditions are the outputs of the variants, in the order they were given.
+	Renditions []Rendition
+}
+
+// Srcset returns the value of the srcset attribute of an img element listing the renditions,
+// with url returning the URL a rendition is published at. Renditions with the same width are listed once.
+func (r *VariantsResult) Srcset(url func(r *Rendition) string) string {
+	var candidates []string
+	seen := map[int]bool{}
+
+	for i := range r.Renditions {
+		rendition := &r.Renditions[i]
+		if seen[rendition.Width] {
+			continue
+		}
+
+		seen[rendition.Width] = true
+		candidates = append(candidates, fmt.Sprintf("%s %dw", url(rendition), rendition.Width))
+	}
+
+	return strings.Join(candidates, ", ")
+}
+
+// Variants encodes renditions of the input at several widths, keeping its aspect ratio, and returns them
+// instead of writing them to Output or OutputFile. Every variant starts from the settings of c,
+// like TargetQuality or Metadata, and is resized with the filter set with Resize.
+// The size set with Resize is ignored.
+//
+// The input is read and decoded only once, scaled down in the DCT domain to the largest width when possible.
+// Concurrency sets the number of variants encoded at the same time.
+func (c *CJpeg) Variants(variants ...Variant) (*VariantsResult, error) {
+	return c.VariantsContext(context.Background(), variants...)
+}
+
+// VariantsContext works like Variants. Encoding is stopped when ctx is done.
+func (c *CJpeg) VariantsContext(ctx context.Context, variants ...Variant) (*VariantsResult, error) {
+	c.result = nil
+
+	if len(variants) == 0 {
+		return nil, errors.New("no variants given")
+	}
+
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	largest := 0
+	for _, variant := range variants {
+		if variant.Width <= 0 {
+			return nil, fmt.Errorf("invalid variant width %d", variant.Width)
+		}
+
+		largest = max(largest, variant.Width)
+	}
+
+	base := *c
+	base.resize = Resize{Filter: c.resize.Filter}
+
+	img, source, err := c.decodeOnce(ctx, largest)
+	if err != nil {
+		return nil, err
+	}
+
+	icc, err := c.outputICC(&cjpegInput{source: source})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &VariantsResult{Renditions: make([]Rendition, len(variants))}
+	errs := make([]error, len(variants))
+	sem := make(chan struct{}, max(c.concurrency, 1))
+	var wg sync.WaitGroup
+
+	for i, variant := range variants {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			trial := base
+			rendition, err := trial.encodeVariant(ctx, variant, img, source, icc)
+			if err != nil {
+				errs[i] = fmt.Errorf("width %d: %w", variant.Width, err)
+				return
+			}
+
+			result.Renditions[i] = *rendition
+		}()
+	}
+
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// decodeOnce decodes the input and composites it over the background. A JPEG input is decoded at a reduced scale
+// if it's still at least width pixels wide. It returns the image along with the content of the input,
+// which is nil for InputImage.
+func (c *CJpeg) decodeOnce(ctx context.Context, width int) (image.Image, []byte, error) {
+	if c.inputImage != nil {
+		return flatten(c.inputImage, c.background), nil, nil
+	}
+
+	var data []byte
+	var err error
+
+	if c.input != nil {
+		data, err = io.ReadAll(c.input)
+	} else if c.inputFile != "" {
+		data, err = os.ReadFile(c.inputFile)
+	} else {
+		err = errors.New("undefined input")
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	format, err := sniffFormat(data[:min(len(data), sniffLength)])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if format.decode == nil {
+		return nil, nil, &UnsupportedFormatError{Format: format.name}
+	}
+
+	var img image.Image
+	if format.name == formatJPEG.name {
+		scaled := *c
+		scaled.resize = Resize{Width: width}
+		img, _, _, _, _ = scaled.decodeJPEGScaled(ctx, data)
+	}
+
+	if img == nil {
+		if img, err = format.decode(bytes.NewReader(data)); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode %s input: %v", format.name, err)
+		}
+	}
+
+	return flatten(img, c.background), data, nil
+}
+
+// encodeVariant resizes img, decoded from source, and encodes it following variant. It changes the settings of c.
+func (c *CJpeg) encodeVariant(ctx context.Context, variant Variant, img image.Image, source, icc []byte) (*Rendition, error) {
+	c.resize.Width = variant.Width
+	if variant.Quality > 0 {
+		c.Quality(variant.Quality)
+	}
+
+	in, err := c.imageInput(resizeImage(img, nil, &c.resize), source)
+	if err != nil {
+		return nil, err
+	}
+
+	data, result, err := c.encodeBuffered(ctx, in, icc)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := in.image.Bounds()
+	return &Rendition{
+		Variant: variant,
+		Width:   bounds.Dx(),
+		Height:  bounds.Dy(),
+		Quality: result.Quality,
+		Size:    len(data),
+		Data:    data,
+		Scans:   indexScans(data),
+	}, nil
+}
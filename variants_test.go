@@ -0,0 +1,99 @@
+package mozjpegbin_test
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCJpegVariants(t *testing.T) {
+	r := &recordingRunner{runner: mozjpegbin.NewEmbeddedRunner()}
+
+	result, err := mozjpegbin.NewCJpegWithRunner(r).
+		Quality(80).
+		Concurrency(3).
+		InputFile("source.jpg").
+		Variants(
+			mozjpegbin.Variant{Width: 320},
+			mozjpegbin.Variant{Width: 640, Quality: 70},
+			mozjpegbin.Variant{Width: 2000},
+		)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	if !assert.Len(t, result.Renditions, 3) {
+		t.FailNow()
+	}
+
+	want := []struct {
+		width, height, quality int
+	}{
+		{320, 240, 80},
+		{640, 479, 70},
+		{1203, 901, 80},
+	}
+
+	for i, rendition := range result.Renditions {
+		assert.Equal(t, want[i].width, rendition.Width)
+		assert.Equal(t, want[i].height, rendition.Height)
+		assert.Equal(t, want[i].quality, rendition.Quality)
+		assert.Equal(t, len(rendition.Data), rendition.Size)
+
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(rendition.Data))
+		if assert.Nil(t, err) {
+			assert.Equal(t, image.Pt(want[i].width, want[i].height), image.Pt(cfg.Width, cfg.Height))
+		}
+	}
+
+	// The input is decoded once, then every variant is encoded.
+	tools := map[string]int{}
+	for _, inv := range r.invocations {
+		tools[inv.Tool]++
+	}
+	assert.Equal(t, map[string]int{"cjpeg": 3}, tools)
+
+	srcset := result.Srcset(func(r *mozjpegbin.Rendition) string {
+		return fmt.Sprintf("/img/photo-%d.jpg", r.Width)
+	})
+	assert.Equal(t, "/img/photo-320.jpg 320w, /img/photo-640.jpg 640w, /img/photo-1203.jpg 1203w", srcset)
+}
+
+func TestCJpegVariantsDCTScale(t *testing.T) {
+	r := &recordingRunner{runner: mozjpegbin.NewEmbeddedRunner()}
+
+	result, err := mozjpegbin.NewCJpegWithRunner(r).
+		InputFile("source.jpg").
+		Variants(mozjpegbin.Variant{Width: 150}, mozjpegbin.Variant{Width: 100})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, 150, result.Renditions[0].Width)
+	assert.Equal(t, 100, result.Renditions[1].Width)
+
+	if assert.NotEmpty(t, r.invocations) {
+		assert.Equal(t, "djpeg", r.invocations[0].Tool)
+		assert.Len(t, r.invocations, 3)
+	}
+}
+
+func TestCJpegVariantsImage(t *testing.T) {
+	c, err := mozjpegbin.NewCJpeg()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	result, err := c.InputImage(testImage()).Variants(mozjpegbin.Variant{Width: 32})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, 32, result.Renditions[0].Width)
+	assert.Equal(t, 24, result.Renditions[0].Height)
+}
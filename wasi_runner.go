@@ -0,0 +1,152 @@
+//go:build mozjpeg_wasi
+
+package mozjpegbin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// WasiRunner runs mozjpeg tools compiled to WASI inside wazero, a pure Go WebAssembly runtime.
+// No processes are spawned and no executables or temp files are written.
+//
+// Modules are read from fsys as "<tool>.wasm", e.g. "cjpeg.wasm".
+// scripts/build_wasi.bash builds them into bin/wasi.
+//
+// Tools only see stdin and stdout unless host directories are mounted with Mount,
+// so InputFile and OutputFile need a mount to work. Input and Output always work.
+// It's safe for concurrent use once configured.
+type WasiRunner struct {
+	fsys   fs.FS
+	mounts map[string]string
+
+	initOnce sync.Once
+	runtime  wazero.Runtime
+	initErr  error
+
+	mu       sync.Mutex
+	compiled map[string]wazero.CompiledModule
+}
+
+// NewWasiRunner creates WasiRunner instance loading modules from fsys
+func NewWasiRunner(fsys fs.FS) *WasiRunner {
+	return &WasiRunner{
+		fsys:     fsys,
+		mounts:   map[string]string{},
+		compiled: map[string]wazero.CompiledModule{},
+	}
+}
+
+// Mount makes hostDir visible to the tools as guestDir.
+func (r *WasiRunner) Mount(hostDir, guestDir string) *WasiRunner {
+	r.mounts[guestDir] = hostDir
+	return r
+}
+
+// Close releases the runtime and all compiled modules.
+func (r *WasiRunner) Close(ctx context.Context) error {
+	if r.runtime == nil {
+		return nil
+	}
+
+	return r.runtime.Close(ctx)
+}
+
+// Run runs inv.Tool inside wazero.
+func (r *WasiRunner) Run(ctx context.Context, inv *Invocation) (*RunResult, error) {
+	compiled, err := r.compile(inv.Tool)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	config := wazero.NewModuleConfig().
+		WithName("").
+		WithArgs(append([]string{inv.Tool}, inv.Args...)...).
+		WithStderr(&stderr)
+
+	if inv.Stdin != nil {
+		config = config.WithStdin(inv.Stdin)
+	}
+
+	if inv.Stdout != nil {
+		config = config.WithStdout(inv.Stdout)
+	} else {
+		config = config.WithStdout(&stdout)
+	}
+
+	if len(r.mounts) > 0 {
+		fsConfig := wazero.NewFSConfig()
+		for guest, host := range r.mounts {
+			fsConfig = fsConfig.WithDirMount(host, guest)
+		}
+		config = config.WithFSConfig(fsConfig)
+	}
+
+	mod, err := r.runtime.InstantiateModule(ctx, compiled, config)
+	if mod != nil {
+		mod.Close(ctx)
+	}
+
+	res := &RunResult{Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}
+
+	if err != nil {
+		var exitErr *sys.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 0 {
+			return res, nil
+		}
+
+		if ctx.Err() != nil {
+			return res, ctx.Err()
+		}
+
+		return res, err
+	}
+
+	return res, nil
+}
+
+func (r *WasiRunner) init() error {
+	r.initOnce.Do(func() {
+		ctx := context.Background()
+		r.runtime = wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+		_, r.initErr = wasi_snapshot_preview1.Instantiate(ctx, r.runtime)
+	})
+
+	return r.initErr
+}
+
+func (r *WasiRunner) compile(tool string) (wazero.CompiledModule, error) {
+	if err := r.init(); err != nil {
+		return nil, fmt.Errorf("failed to init wasi runtime: %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if compiled, ok := r.compiled[tool]; ok {
+		return compiled, nil
+	}
+
+	bin, err := fs.ReadFile(r.fsys, tool+".wasm")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s module: %v", tool, err)
+	}
+
+	compiled, err := r.runtime.CompileModule(context.Background(), bin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile %s module: %v", tool, err)
+	}
+
+	r.compiled[tool] = compiled
+	return compiled, nil
+}
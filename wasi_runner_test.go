@@ -0,0 +1,72 @@
+//go:build mozjpeg_wasi
+
+package mozjpegbin_test
+
+import (
+	"bytes"
+	"context"
+	"image/jpeg"
+	"os"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newWasiRunner(t *testing.T) *mozjpegbin.WasiRunner {
+	if _, err := os.Stat("bin/wasi/cjpeg.wasm"); err != nil {
+		t.Skip("wasi modules are not built, run scripts/build_wasi.bash")
+	}
+
+	r := mozjpegbin.NewWasiRunner(os.DirFS("bin/wasi"))
+	t.Cleanup(func() { r.Close(context.Background()) })
+	return r
+}
+
+func TestWasiCJpeg(t *testing.T) {
+	r := newWasiRunner(t)
+
+	f, err := os.Open("source.jpg")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer f.Close()
+
+	var out bytes.Buffer
+	err = mozjpegbin.NewCJpegWithRunner(r).Quality(80).Input(f).Output(&out).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	_, err = jpeg.Decode(&out)
+	assert.Nil(t, err)
+}
+
+func TestWasiJpegTran(t *testing.T) {
+	r := newWasiRunner(t)
+
+	f, err := os.Open("source.jpg")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer f.Close()
+
+	var out bytes.Buffer
+	err = mozjpegbin.NewJpegTranWithRunner(r).Progressive(true).Input(f).Output(&out).Run()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	_, err = jpeg.Decode(&out)
+	assert.Nil(t, err)
+}
+
+func TestWasiVersion(t *testing.T) {
+	r := newWasiRunner(t)
+
+	v, err := mozjpegbin.NewCJpegWithRunner(r).Version()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	assert.Contains(t, v, "mozjpeg")
+}
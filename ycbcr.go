@@ -0,0 +1,515 @@
+package mozjpegbin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+)
+
+// ycbcrEncoder is implemented by runners able to compress YCbCr planes themselves.
+type ycbcrEncoder interface {
+	// encodeYCbCr compresses img keeping its subsampling. quality is between 0 and 100, or -1 for the default.
+	// It returns errUnsupportedSubsampling if the runner can't handle the subsampling of img.
+	encodeYCbCr(img *image.YCbCr, quality int) ([]byte, error)
+}
+
+var errUnsupportedSubsampling = errors.New("unsupported subsampling")
+
+// samplingFactors returns the horizontal and vertical sampling factors of the luma component
+// for ratio. Chroma components always have factors of 1.
+func samplingFactors(ratio image.YCbCrSubsampleRatio) (int, int, error) {
+	switch ratio {
+	case image.YCbCrSubsampleRatio444:
+		return 1, 1, nil
+	case image.YCbCrSubsampleRatio422:
+		return 2, 1, nil
+	case image.YCbCrSubsampleRatio420:
+		return 2, 2, nil
+	case image.YCbCrSubsampleRatio440:
+		return 1, 2, nil
+	case image.YCbCrSubsampleRatio411:
+		return 4, 1, nil
+	case image.YCbCrSubsampleRatio410:
+		return 4, 2, nil
+	default:
+		return 0, 0, fmt.Errorf("%w %v", errUnsupportedSubsampling, ratio)
+	}
+}
+
+// zigzag maps zig-zag order to natural order.
+var zigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// Quantization tables from section K.1 of the JPEG spec, in natural order.
+var (
+	stdLuminanceQuant = [64]int{
+		16, 11, 10, 16, 24, 40, 51, 61,
+		12, 12, 14, 19, 26, 58, 60, 55,
+		14, 13, 16, 24, 40, 57, 69, 56,
+		14, 17, 22, 29, 51, 87, 80, 62,
+		18, 22, 37, 56, 68, 109, 103, 77,
+		24, 35, 55, 64, 81, 104, 113, 92,
+		49, 64, 78, 87, 103, 121, 120, 101,
+		72, 92, 95, 98, 112, 100, 103, 99,
+	}
+	stdChrominanceQuant = [64]int{
+		17, 18, 24, 47, 99, 99, 99, 99,
+		18, 21, 26, 66, 99, 99, 99, 99,
+		24, 26, 56, 99, 99, 99, 99, 99,
+		47, 66, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	}
+)
+
+// scaleQuant scales a base table by an IJG quality factor, like jpeg_set_quality with force_baseline.
+func scaleQuant(base *[64]int, quality int) [64]int {
+	if quality <= 0 {
+		quality = 1
+	} else if quality > 100 {
+		quality = 100
+	}
+
+	scale := 200 - quality*2
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+
+	var table [64]int
+	for i, v := range base {
+		table[i] = min(max((v*scale+50)/100, 1), 255)
+	}
+
+	return table
+}
+
+// huffmanSpec is a Huffman table as stored in a DHT segment.
+type huffmanSpec struct {
+	class, id byte
+	// counts[i] is the number of codes of length i+1 bits.
+	counts [16]byte
+	values []byte
+}
+
+// Huffman tables from section K.3 of the JPEG spec. jpegtran replaces them with optimized ones.
+var stdHuffmanSpecs = [4]huffmanSpec{
+	{
+		class:  0,
+		id:     0,
+		counts: [16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		values: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		class:  1,
+		id:     0,
+		counts: [16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		values: []byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12, 0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08, 0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	{
+		class:  0,
+		id:     1,
+		counts: [16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		values: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		class:  1,
+		id:     1,
+		counts: [16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		values: []byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21, 0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91, 0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34, 0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+// huffmanCode is a code word and its length in bits.
+type huffmanCode struct {
+	code uint32
+	size uint32
+}
+
+func (s *huffmanSpec) codes() [256]huffmanCode {
+	var codes [256]huffmanCode
+	code, k := uint32(0), 0
+
+	for i, n := range s.counts {
+		for j := 0; j < int(n); j++ {
+			codes[s.values[k]] = huffmanCode{code: code, size: uint32(i + 1)}
+			code++
+			k++
+		}
+		code <<= 1
+	}
+
+	return codes
+}
+
+// dctCos[u][x] is C(u)/2 * cos((2x+1)uπ/16), the basis of the 8x8 forward DCT.
+var dctCos = func() [8][8]float64 {
+	var c [8][8]float64
+	for u := 0; u < 8; u++ {
+		cu := 0.5
+		if u == 0 {
+			cu = 0.5 / math.Sqrt2
+		}
+
+		for x := 0; x < 8; x++ {
+			c[u][x] = cu * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+
+	return c
+}()
+
+// fdct transforms a level shifted block in place.
+func fdct(block *[64]float64) {
+	var tmp [64]float64
+
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for x := 0; x < 8; x++ {
+				sum += block[y*8+x] * dctCos[u][x]
+			}
+			tmp[y*8+u] = sum
+		}
+	}
+
+	for u := 0; u < 8; u++ {
+		for v := 0; v < 8; v++ {
+			var sum float64
+			for y := 0; y < 8; y++ {
+				sum += tmp[y*8+u] * dctCos[v][y]
+			}
+			block[v*8+u] = sum
+		}
+	}
+}
+
+// bitWriter writes entropy coded data, stuffing a zero byte after every 0xFF.
+type bitWriter struct {
+	w     *bufio.Writer
+	bits  uint32
+	nBits uint32
+}
+
+func (b *bitWriter) emit(bits, n uint32) {
+	b.bits |= (bits & (1<<n - 1)) << (32 - b.nBits - n)
+	b.nBits += n
+
+	for b.nBits >= 8 {
+		c := byte(b.bits >> 24)
+		b.w.WriteByte(c)
+		if c == 0xFF {
+			b.w.WriteByte(0)
+		}
+
+		b.bits <<= 8
+		b.nBits -= 8
+	}
+}
+
+func (b *bitWriter) flush() {
+	// Pad the last byte with ones.
+	if b.nBits > 0 {
+		b.emit(0x7F, 8-b.nBits)
+	}
+}
+
+// bitLength returns the JPEG magnitude category of v.
+func bitLength(v int) uint32 {
+	if v < 0 {
+		v = -v
+	}
+
+	n := uint32(0)
+	for v > 0 {
+		n++
+		v >>= 1
+	}
+
+	return n
+}
+
+// ycbcrComponent is a plane of an image.YCbCr being encoded.
+type ycbcrComponent struct {
+	pix            []byte
+	stride         int
+	width, height  int
+	h, v           int
+	quant          *[64]int
+	dc, ac         *[256]huffmanCode
+	pred           int
+	quantID, tblID byte
+}
+
+// sample returns the pixel at x, y replicating the plane edges.
+func (c *ycbcrComponent) sample(x, y int) byte {
+	return c.pix[min(y, c.height-1)*c.stride+min(x, c.width-1)]
+}
+
+func (c *ycbcrComponent) encodeBlock(bw *bitWriter, x0, y0 int) {
+	var block [64]float64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			block[y*8+x] = float64(c.sample(x0+x, y0+y)) - 128
+		}
+	}
+
+	fdct(&block)
+
+	var coeffs [64]int
+	for i := range coeffs {
+		coeffs[i] = int(math.Round(block[zigzag[i]] / float64(c.quant[zigzag[i]])))
+	}
+
+	diff := coeffs[0] - c.pred
+	c.pred = coeffs[0]
+	emitValue(bw, c.dc, 0, diff)
+
+	run := 0
+	for i := 1; i < 64; i++ {
+		if coeffs[i] == 0 {
+			run++
+			continue
+		}
+
+		for run > 15 {
+			emitCode(bw, c.ac, 0xF0)
+			run -= 16
+		}
+
+		emitValue(bw, c.ac, run, coeffs[i])
+		run = 0
+	}
+
+	if run > 0 {
+		emitCode(bw, c.ac, 0x00)
+	}
+}
+
+func emitCode(bw *bitWriter, codes *[256]huffmanCode, symbol byte) {
+	code := codes[symbol]
+	bw.emit(code.code, code.size)
+}
+
+func emitValue(bw *bitWriter, codes *[256]huffmanCode, run int, v int) {
+	size := bitLength(v)
+	emitCode(bw, codes, byte(run<<4)|byte(size))
+
+	if v < 0 {
+		v--
+	}
+
+	if size > 0 {
+		bw.emit(uint32(v), size)
+	}
+}
+
+// encodeYCbCrBaseline writes img as a baseline JPEG without converting it to RGB,
+// keeping its subsampling. It uses the standard quantization and Huffman tables,
+// the result is meant to be optimized losslessly by jpegtran afterwards.
+func encodeYCbCrBaseline(w io.Writer, img *image.YCbCr, quality int) error {
+	h, v, err := samplingFactors(img.SubsampleRatio)
+	if err != nil {
+		return err
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= 0 || height <= 0 || width > 0xFFFF || height > 0xFFFF {
+		return fmt.Errorf("invalid image size %dx%d", width, height)
+	}
+
+	if quality < 0 {
+		quality = 75
+	}
+
+	luma := scaleQuant(&stdLuminanceQuant, quality)
+	chroma := scaleQuant(&stdChrominanceQuant, quality)
+	var codes [4][256]huffmanCode
+	for i := range stdHuffmanSpecs {
+		codes[i] = stdHuffmanSpecs[i].codes()
+	}
+
+	chromaWidth := (bounds.Max.X+h-1)/h - bounds.Min.X/h
+	chromaHeight := (bounds.Max.Y+v-1)/v - bounds.Min.Y/v
+
+	components := []*ycbcrComponent{
+		{
+			pix: img.Y[img.YOffset(bounds.Min.X, bounds.Min.Y):], stride: img.YStride,
+			width: width, height: height, h: h, v: v,
+			quant: &luma, dc: &codes[0], ac: &codes[1], quantID: 0, tblID: 0x00,
+		},
+		{
+			pix: img.Cb[img.COffset(bounds.Min.X, bounds.Min.Y):], stride: img.CStride,
+			width: chromaWidth, height: chromaHeight, h: 1, v: 1,
+			quant: &chroma, dc: &codes[2], ac: &codes[3], quantID: 1, tblID: 0x11,
+		},
+		{
+			pix: img.Cr[img.COffset(bounds.Min.X, bounds.Min.Y):], stride: img.CStride,
+			width: chromaWidth, height: chromaHeight, h: 1, v: 1,
+			quant: &chroma, dc: &codes[2], ac: &codes[3], quantID: 1, tblID: 0x11,
+		},
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.Write(ycbcrHeader(width, height, components))
+
+	bits := &bitWriter{w: bw}
+	mcuWidth, mcuHeight := 8*h, 8*v
+
+	for my := 0; my*mcuHeight < height; my++ {
+		for mx := 0; mx*mcuWidth < width; mx++ {
+			for _, c := range components {
+				for by := 0; by < c.v; by++ {
+					for bx := 0; bx < c.h; bx++ {
+						c.encodeBlock(bits, (mx*c.h+bx)*8, (my*c.v+by)*8)
+					}
+				}
+			}
+		}
+	}
+
+	bits.flush()
+	bw.Write([]byte{0xFF, markerEOI})
+	return bw.Flush()
+}
+
+// ycbcrComponentIDs maps the component IDs written by cjpeg -rgb to the ones of a YCbCr frame.
+var ycbcrComponentIDs = map[byte]byte{'R': 1, 'G': 2, 'B': 3}
+
+// relabelYCbCr marks the output of cjpeg -rgb for YCbCr planes as a YCbCr file:
+// the Adobe segment telling there's no color transform is replaced with a JFIF one and components are renamed.
+func relabelYCbCr(data []byte) ([]byte, error) {
+	segments, rest, err := splitHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []segment{{marker: markerAPP0, data: jfifSegment}}
+	for _, s := range segments {
+		if s.marker == markerAPP14 && s.hasPrefix("Adobe") {
+			continue
+		}
+
+		if isSOF(s.marker) {
+			if len(s.data) < 6 || len(s.data) < 6+int(s.data[5])*3 {
+				return nil, errors.New("invalid SOF segment")
+			}
+
+			s.data = bytes.Clone(s.data)
+			for i := 0; i < int(s.data[5]); i++ {
+				s.data[6+i*3] = ycbcrComponentIDs[s.data[6+i*3]]
+			}
+		}
+
+		out = append(out, s)
+	}
+
+	rest = bytes.Clone(rest)
+	for pos := 0; pos+4 <= len(rest) && rest[pos+1] != markerEOI; {
+		length := int(binary.BigEndian.Uint16(rest[pos+2:]))
+		if length < 2 || pos+2+length > len(rest) {
+			return nil, fmt.Errorf("invalid length of jpeg marker 0x%X", rest[pos+1])
+		}
+
+		if rest[pos+1] != markerSOS {
+			pos += 2 + length
+			continue
+		}
+
+		if length < 3 || length < 3+int(rest[pos+4])*2 {
+			return nil, errors.New("invalid SOS segment")
+		}
+
+		for i := 0; i < int(rest[pos+4]); i++ {
+			rest[pos+5+i*2] = ycbcrComponentIDs[rest[pos+5+i*2]]
+		}
+
+		pos = skipEntropyData(rest, pos+2+length)
+	}
+
+	return joinHeader(out, rest), nil
+}
+
+// jfifSegment is the payload of the JFIF segment written by cjpeg: version 1.01, no density unit, no thumbnail.
+var jfifSegment = []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00")
+
+func ycbcrHeader(width, height int, components []*ycbcrComponent) []byte {
+	var segments []segment
+
+	segments = append(segments, segment{marker: markerAPP0, data: jfifSegment})
+
+	var dqt []byte
+	for id, table := range []*[64]int{components[0].quant, components[1].quant} {
+		dqt = append(dqt, byte(id))
+		for i := range table {
+			dqt = append(dqt, byte(table[zigzag[i]]))
+		}
+	}
+	segments = append(segments, segment{marker: markerDQT, data: dqt})
+
+	sof := []byte{8}
+	sof = binary.BigEndian.AppendUint16(sof, uint16(height))
+	sof = binary.BigEndian.AppendUint16(sof, uint16(width))
+	sof = append(sof, byte(len(components)))
+	for i, c := range components {
+		sof = append(sof, byte(i+1), byte(c.h<<4|c.v), c.quantID)
+	}
+	segments = append(segments, segment{marker: 0xC0, data: sof})
+
+	var dht []byte
+	for _, s := range stdHuffmanSpecs {
+		dht = append(dht, s.class<<4|s.id)
+		dht = append(dht, s.counts[:]...)
+		dht = append(dht, s.values...)
+	}
+	segments = append(segments, segment{marker: 0xC4, data: dht})
+
+	sos := []byte{0xFF, markerSOS}
+	sos = binary.BigEndian.AppendUint16(sos, uint16(6+2*len(components)))
+	sos = append(sos, byte(len(components)))
+	for i, c := range components {
+		sos = append(sos, byte(i+1), c.tblID)
+	}
+	sos = append(sos, 0, 63, 0)
+
+	return joinHeader(segments, sos)
+}
@@ -0,0 +1,99 @@
+package mozjpegbin_test
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+
+	"github.com/Munchpass/go-mozjpegbin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestYCbCr(ratio image.YCbCrSubsampleRatio) *image.YCbCr {
+	img := image.NewYCbCr(image.Rect(0, 0, 99, 51), ratio)
+
+	for y := 0; y < 51; y++ {
+		for x := 0; x < 99; x++ {
+			img.Y[img.YOffset(x, y)] = uint8(x*2 + y)
+			img.Cb[img.COffset(x, y)] = uint8(128 + x - y)
+			img.Cr[img.COffset(x, y)] = uint8(200 - x)
+		}
+	}
+
+	return img
+}
+
+func TestEncodeYCbCrKeepsSubsampling(t *testing.T) {
+	ratios := []image.YCbCrSubsampleRatio{
+		image.YCbCrSubsampleRatio444,
+		image.YCbCrSubsampleRatio422,
+		image.YCbCrSubsampleRatio420,
+		image.YCbCrSubsampleRatio440,
+		image.YCbCrSubsampleRatio411,
+		image.YCbCrSubsampleRatio410,
+	}
+
+	for _, ratio := range ratios {
+		src := newTestYCbCr(ratio)
+
+		c, err := mozjpegbin.NewCJpeg()
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+
+		var out bytes.Buffer
+		err = c.Quality(95).InputImage(src).Output(&out).Run()
+		if !assert.Nil(t, err, "ratio %v", ratio) {
+			continue
+		}
+
+		decoded, err := jpeg.Decode(&out)
+		if !assert.Nil(t, err, "ratio %v", ratio) {
+			continue
+		}
+
+		ycbcr, ok := decoded.(*image.YCbCr)
+		if !assert.True(t, ok, "ratio %v", ratio) {
+			continue
+		}
+
+		assert.Equal(t, ratio, ycbcr.SubsampleRatio)
+		assert.Equal(t, src.Bounds(), ycbcr.Bounds())
+
+		var diff int
+		for i := range src.Y {
+			d := int(src.Y[i]) - int(ycbcr.Y[ycbcr.YOffset(i%src.YStride, i/src.YStride)])
+			diff += max(d, -d)
+		}
+		assert.Less(t, float64(diff)/float64(len(src.Y)), 2.0, "ratio %v", ratio)
+
+		diff = 0
+		for i := range src.Cb {
+			x, y := i%src.CStride, i/src.CStride
+			for _, d := range []int{
+				int(src.Cb[i]) - int(ycbcr.Cb[y*ycbcr.CStride+x]),
+				int(src.Cr[i]) - int(ycbcr.Cr[y*ycbcr.CStride+x]),
+			} {
+				diff += max(d, -d)
+			}
+		}
+		assert.Less(t, float64(diff)/float64(len(src.Cb)*2), 2.0, "ratio %v", ratio)
+	}
+}
+
+func TestEncodeYCbCr(t *testing.T) {
+	src := newTestYCbCr(image.YCbCrSubsampleRatio422)
+
+	var out bytes.Buffer
+	err := mozjpegbin.Encode(&out, src, &mozjpegbin.Options{Quality: 90})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	decoded, err := jpeg.Decode(&out)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, image.YCbCrSubsampleRatio422, decoded.(*image.YCbCr).SubsampleRatio)
+}